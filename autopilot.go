@@ -24,6 +24,7 @@
 package tello
 
 import (
+	"context"
 	"errors"
 	"log"
 	"math"
@@ -43,6 +44,12 @@ const (
 	AutoXYToleranceM = 0.3
 	// AutoXYNearTargetM is how close to the target we slow down for finer navigation
 	AutoXYNearTargetM = 3.0
+	// TakeOffStableWindow is how long the drone must hold station before TakeOffAndSetHome() sets the home point.
+	TakeOffStableWindow = time.Second
+	// TakeOffStableM is how far the drone may drift in each axis during TakeOffStableWindow.
+	TakeOffStableM = 0.1
+	// TakeOffStableDeg is how far the drone may turn during TakeOffStableWindow.
+	TakeOffStableDeg = 3
 )
 
 // CancelAutoFlyToHeight stops any in-flight AutoFlyToHeight navigation.
@@ -60,17 +67,29 @@ func (tello *Tello) CancelAutoFlyToHeight() {
 // The caller may optionally listen on the 'done' channel for a signal that
 // the navigation is complete (or has been cancelled).
 func (tello *Tello) AutoFlyToHeight(dm int16) (done chan bool, err error) {
+	if err = tello.checkArmed(); err != nil {
+		return nil, err
+	}
+	if tello.IsAutoTuning() {
+		return nil, errAutoTuning
+	}
 	//log.Printf("AutoFlyToHeight called with height: %d\n", dm)
 	if dm > AutoHeightLimitDm || dm < -AutoHeightLimitDm {
 		return nil, errors.New("Verical navigation limit exceeded")
 	}
+	if !tello.fenceAllowsHeight(float32(dm) / 10) {
+		return nil, errors.New("Target height is outside the geofence")
+	}
+	if maxM := tello.GetFlightData().MaxHeight; maxM > 0 && dm > int16(maxM)*10 {
+		return nil, errors.New("Target height is above the drone's maximum height")
+	}
 	// are we already navigating?
-	tello.autoHeightMu.RLock()
-	if tello.autoHeight {
-		tello.autoHeightMu.RUnlock()
+	if tello.isAutoHeight() {
 		return nil, errors.New("Already navigating vertically")
 	}
-	tello.autoHeightMu.RUnlock()
+	if tello.IsAutoTrajectory() || tello.IsAutoFollowing() {
+		return nil, errors.New("Cannot navigate vertically while following a trajectory or target")
+	}
 
 	tello.autoHeightMu.Lock()
 	tello.autoHeight = true
@@ -101,18 +120,19 @@ func (tello *Tello) AutoFlyToHeight(dm int16) (done chan bool, err error) {
 			delta := dm - tello.fd.Height // delta will be positive if we are too low
 			//log.Printf("Target: %d, Height: %d, Delta: %d\n", dm, tello.fd.Height, delta)
 			tello.fdMu.RUnlock()
+			gains := tello.GetAutopilotGains()
 
 			tello.ctrlMu.Lock()
 			switch {
-			case delta > 4:
-				tello.ctrlLy = autoPilotSpeedFast // full throttle if >40cm off target
-			case delta > 0:
-				tello.ctrlLy = autoPilotSpeedSlow // half throttle if <40cm off target
-			case delta < -4:
+			case delta > gains.HeightNearDm:
+				tello.ctrlLy = autoPilotSpeedFast // full throttle if far off target
+			case delta > gains.HeightToleranceDm:
+				tello.ctrlLy = autoPilotSpeedSlow // half throttle if near target
+			case delta < -gains.HeightNearDm:
 				tello.ctrlLy = -autoPilotSpeedFast
-			case delta < 0:
+			case delta < -gains.HeightToleranceDm:
 				tello.ctrlLy = -autoPilotSpeedSlow
-			case delta == 0: // might need some 'tolerance' here?
+			default:
 				// we're there! Cancel...
 				tello.autoHeightMu.Lock()
 				tello.autoHeight = false
@@ -128,6 +148,14 @@ func (tello *Tello) AutoFlyToHeight(dm int16) (done chan bool, err error) {
 	return done, nil
 }
 
+// isAutoHeight tests whether we are currently navigating vertically
+func (tello *Tello) isAutoHeight() (set bool) {
+	tello.autoHeightMu.RLock()
+	set = tello.autoHeight
+	tello.autoHeightMu.RUnlock()
+	return set
+}
+
 // CancelAutoTurn stops any in-flight AutoTurnToYaw or AutoTurnByDeg navigation.
 // The drone should stop rotating.
 func (tello *Tello) CancelAutoTurn() {
@@ -143,6 +171,12 @@ func (tello *Tello) CancelAutoTurn() {
 // the navigation is complete (may have been cancelled).
 // You may explicitly cancel this operation via CancelAutoTurn().
 func (tello *Tello) AutoTurnToYaw(targetYaw int16) (done chan bool, err error) {
+	if err = tello.checkArmed(); err != nil {
+		return nil, err
+	}
+	if tello.IsAutoTuning() {
+		return nil, errAutoTuning
+	}
 	//log.Printf("AutoTurnToYaw called with target: %d\n", targetYaw)
 	if targetYaw < -180 || targetYaw > 180 {
 		return nil, errors.New("Target yaw must be between -180 and +180")
@@ -159,6 +193,9 @@ func (tello *Tello) AutoTurnToYaw(targetYaw int16) (done chan bool, err error) {
 		return nil, errors.New("Already navigating rotationally")
 	}
 	tello.autoYawMu.RUnlock()
+	if tello.IsAutoFollowing() {
+		return nil, errors.New("Cannot navigate rotationally while following a target")
+	}
 
 	tello.autoYawMu.Lock()
 	tello.autoYaw = true
@@ -203,18 +240,19 @@ func (tello *Tello) AutoTurnToYaw(targetYaw int16) (done chan bool, err error) {
 			}
 
 			//log.Printf("Target: %d, Current: %d, Delta: %d\n", adjustedTarget, adjustedCurrent, delta)
+			gains := tello.GetAutopilotGains()
 
 			tello.ctrlMu.Lock()
 			switch {
-			case delta > 10:
+			case delta > gains.YawNearDeg:
 				tello.ctrlLx = autoPilotSpeedFast
-			case delta > 0:
+			case delta > gains.YawToleranceDeg:
 				tello.ctrlLx = autoPilotSpeedSlow
-			case delta < -10:
+			case delta < -gains.YawNearDeg:
 				tello.ctrlLx = -autoPilotSpeedFast
-			case delta < 0:
+			case delta < -gains.YawToleranceDeg:
 				tello.ctrlLx = -autoPilotSpeedSlow
-			case delta == 0: // might need some 'tolerance' here?
+			default:
 				// we're there! Cancel...
 				tello.autoYawMu.Lock()
 				tello.autoYaw = false
@@ -272,19 +310,160 @@ func (tello *Tello) AutoTurnByDeg(delta int16) (done chan bool, err error) {
 	return tello.AutoTurnToYaw(adjustedTarget)
 }
 
-// // autoWaitAndSetOrigin is run as a Goroutine after takeoff is initiated.
-// func (tello *Tello) autoWaitAndSetOrigin() {
+// cancelAllAutopilots stops every running autopilot, then gives their Goroutines time to
+// notice and stop driving the sticks.
+func (tello *Tello) cancelAllAutopilots() {
+	tello.CancelAutoFollow()
+	tello.CancelAutoFlyPath()
+	tello.CancelAutoFlyTrajectory()
+	tello.CancelAutoTune()
+	tello.CancelAutoFlyToXY()
+	tello.CancelAutoFlyToHeight()
+	tello.CancelAutoTurn()
+	time.Sleep(2 * autopilotPeriodMs * time.Millisecond)
+}
+
+// AutoReturnHome cancels any running autopilots, then flies back to the home point
+// (which must have been previously set) at the current height.
+// If land is set then the drone lands once it has arrived.
+// The func returns immediately and a Goroutine handles the navigation, which may
+// be cancelled via CancelAutoFlyToXY().
+// The caller may optionally listen on the 'done' channel for a signal that
+// the navigation is complete (or has been cancelled).
+func (tello *Tello) AutoReturnHome(land bool) (done chan bool, err error) {
+	if !tello.IsHomeSet() {
+		return nil, errors.New("Cannot return home as home point has not be set (or is invalid)")
+	}
+	tello.cancelAllAutopilots()
+	xyDone, err := tello.AutoFlyToXY(0, 0)
+	if err != nil {
+		return nil, err
+	}
+	done = make(chan bool, 1) // buffered so send doesn't block
+	go func() {
+		<-xyDone
+		x, y, _, _ := tello.homeRelativePos()
+		tol := tello.GetAutopilotGains().XYToleranceM * 1.5
+		if land && x*x+y*y <= tol*tol { // arrived rather than cancelled
+			tello.Land()
+		}
+		done <- true
+	}()
+	return done, nil
+}
+
+// poseSample is a position and yaw used to judge whether the drone is holding station.
+type poseSample struct {
+	x, y, z float32
+	yaw     int16
+}
+
+// isStable tests whether a set of pose samples lie within the takeoff stability limits.
+func isStable(samples []poseSample) bool {
+	if len(samples) < 2 {
+		return false
+	}
+	minS, maxS := samples[0], samples[0]
+	for _, s := range samples[1:] {
+		minS.x, maxS.x = float32(math.Min(float64(minS.x), float64(s.x))), float32(math.Max(float64(maxS.x), float64(s.x)))
+		minS.y, maxS.y = float32(math.Min(float64(minS.y), float64(s.y))), float32(math.Max(float64(maxS.y), float64(s.y)))
+		minS.z, maxS.z = float32(math.Min(float64(minS.z), float64(s.z))), float32(math.Max(float64(maxS.z), float64(s.z)))
+		if d := s.yaw - samples[0].yaw; d > TakeOffStableDeg && d < 360-TakeOffStableDeg || d < -TakeOffStableDeg && d > TakeOffStableDeg-360 {
+			return false
+		}
+	}
+	return maxS.x-minS.x <= TakeOffStableM && maxS.y-minS.y <= TakeOffStableM && maxS.z-minS.z <= TakeOffStableM
+}
 
-// }
+// awaitStable waits until the drone has held station, with fresh MVO position data, for TakeOffStableWindow.
+func (tello *Tello) awaitStable(ctx context.Context) error {
+	var samples []poseSample
+	for {
+		tello.fdMu.RLock()
+		fresh := time.Since(tello.mvoPosUpdated) < 5*autopilotPeriodMs*time.Millisecond
+		s := poseSample{tello.fd.MVO.PositionX, tello.fd.MVO.PositionY, tello.fd.MVO.PositionZ, tello.fd.IMU.Yaw}
+		tello.fdMu.RUnlock()
+		if fresh {
+			samples = append(samples, s)
+		} else {
+			samples = samples[:0]
+		}
+		window := int(TakeOffStableWindow / (autopilotPeriodMs * time.Millisecond))
+		if len(samples) > window {
+			samples = samples[len(samples)-window:]
+			if isStable(samples) {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			if len(samples) == 0 {
+				return errors.New("No valid MVO position data received")
+			}
+			return errors.New("Drone did not settle into a stable hover")
+		case <-time.After(autopilotPeriodMs * time.Millisecond):
+		}
+	}
+}
+
+// TakeOffAndSetHome takes off, waits for a stable hover with valid MVO position and yaw data,
+// optionally climbs (or descends) to heightDm decimetres (pass zero to stay at the takeoff height),
+// and then sets the home point.
+// The func returns as soon as the takeoff has been sent, and a Goroutine handles the rest of the
+// sequence.  The result is sent on the 'done' channel: nil if the home point was set, otherwise the
+// reason for failure.  The whole sequence must complete within timeout.
+func (tello *Tello) TakeOffAndSetHome(heightDm int16, timeout time.Duration) (done chan error, err error) {
+	if heightDm < 0 || heightDm > AutoHeightLimitDm {
+		return nil, errors.New("Target height out of range")
+	}
+	if heightDm > 0 && !tello.fenceAllowsHeight(float32(heightDm)/10) {
+		return nil, errors.New("Target height is outside the geofence")
+	}
+	if err = tello.TakeOff(); err != nil {
+		return nil, err
+	}
+	done = make(chan error, 1) // buffered so send doesn't block
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := tello.WaitForPhase(ctx, PhaseHovering); err != nil {
+			done <- errors.New("Timeout waiting for takeoff to complete")
+			return
+		}
+		if err := tello.awaitStable(ctx); err != nil {
+			done <- err
+			return
+		}
+		if heightDm > 0 {
+			hDone, err := tello.AutoFlyToHeight(heightDm)
+			if err != nil {
+				done <- err
+				return
+			}
+			select {
+			case <-hDone:
+			case <-ctx.Done():
+				tello.CancelAutoFlyToHeight()
+				done <- errors.New("Timeout waiting to reach the target height")
+				return
+			}
+			if err := tello.awaitStable(ctx); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- tello.SetHome()
+	}()
+	return done, nil
+}
 
 // SetHome establishes the current MVO position and IMU yaw as the home
 // point for autopilot operations.  It could be called after takeoff to establish a
 // home coordinate, or during (non-autopilot) flight to set a waypoint.
+// It is refused while any autopilot which steers relative to home is running
+// (AutoFlyToXY, AutoFlyPath, AutoFlyTrajectory, AutoFollow or AutoNavigateTo).
 func (tello *Tello) SetHome() (err error) {
-	tello.autoXYMu.RLock()
-	alreadyAuto := tello.autoXY
-	tello.autoXYMu.RUnlock()
-	if alreadyAuto {
+	if tello.IsAutoXY() || tello.IsAutoTrajectory() || tello.IsAutoFollowing() || tello.IsAutoPath() {
 		return errors.New("Cannot set origin during automatic flight")
 	}
 	tello.autoXYMu.Lock()
@@ -333,15 +512,27 @@ func (tello *Tello) IsAutoXY() (set bool) {
 // The caller may optionally listen on the 'done' channel for a signal that
 // the navigation is complete (or has been cancelled).
 func (tello *Tello) AutoFlyToXY(targetX, targetY float32) (done chan bool, err error) {
+	if err = tello.checkArmed(); err != nil {
+		return nil, err
+	}
+	if tello.IsAutoTuning() {
+		return nil, errAutoTuning
+	}
 	//log.Printf("FlyToXY called with XY: %d\n", dm)
 	if targetX > AutoXYLimitM || targetY > AutoXYLimitM ||
 		targetX < -AutoXYLimitM || targetY < -AutoXYLimitM {
 		return nil, errors.New("Horizontal navigation limit exceeded")
 	}
+	if !tello.fenceAllowsXY(targetX, targetY) {
+		return nil, errors.New("Target is outside the geofence")
+	}
 	// are we already navigating?
 	if tello.IsAutoXY() {
 		return nil, errors.New("Already AutoFlying horizontally")
 	}
+	if tello.IsAutoTrajectory() || tello.IsAutoFollowing() {
+		return nil, errors.New("Cannot AutoFly horizontally while following a trajectory or target")
+	}
 
 	// is home position valid?
 	tello.autoXYMu.RLock()
@@ -404,33 +595,34 @@ func (tello *Tello) AutoFlyToXY(targetX, targetY float32) (done chan bool, err e
 			}
 
 			deltaX, deltaY := calcXYdeltas(currentYaw, currentX, currentY, targetX, targetY)
+			gains := tello.GetAutopilotGains()
 
 			tello.ctrlMu.Lock()
 
 			switch {
-			case deltaX <= AutoXYToleranceM && deltaX >= -AutoXYToleranceM:
+			case deltaX <= gains.XYToleranceM && deltaX >= -gains.XYToleranceM:
 				tello.ctrlRx = 0
-			case deltaX >= AutoXYNearTargetM:
-				tello.ctrlRx = autoPilotSpeedFast // full throttle if =>AutoXYNearTargetM off target
-			case deltaX <= -AutoXYNearTargetM:
-				tello.ctrlRx = -autoPilotSpeedFast // full throttle if =>AutoXYNearTargetM off target
-			case deltaX > AutoXYToleranceM:
+			case deltaX >= gains.XYNearM:
+				tello.ctrlRx = autoPilotSpeedFast // full throttle if =>gains.XYNearM off target
+			case deltaX <= -gains.XYNearM:
+				tello.ctrlRx = -autoPilotSpeedFast // full throttle if =>gains.XYNearM off target
+			case deltaX > gains.XYToleranceM:
 				tello.ctrlRx = autoPilotSpeedSlow // half throttle
-			case deltaX < -AutoXYToleranceM:
+			case deltaX < -gains.XYToleranceM:
 				tello.ctrlRx = -autoPilotSpeedSlow // half throttle
 			default:
 				log.Fatalf("Invalid state in AutoFlyToXY() - deltaX=%f", deltaX)
 			}
 			switch {
-			case deltaY <= AutoXYToleranceM && deltaY >= -AutoXYToleranceM:
+			case deltaY <= gains.XYToleranceM && deltaY >= -gains.XYToleranceM:
 				tello.ctrlRy = 0
-			case deltaY >= AutoXYNearTargetM:
-				tello.ctrlRy = autoPilotSpeedFast // full throttle if =>AutoXYNearTargetM off target
-			case deltaY <= -AutoXYNearTargetM:
-				tello.ctrlRy = -autoPilotSpeedFast // full throttle if =>AutoXYNearTargetM off target
-			case deltaY > AutoXYToleranceM:
+			case deltaY >= gains.XYNearM:
+				tello.ctrlRy = autoPilotSpeedFast // full throttle if =>gains.XYNearM off target
+			case deltaY <= -gains.XYNearM:
+				tello.ctrlRy = -autoPilotSpeedFast // full throttle if =>gains.XYNearM off target
+			case deltaY > gains.XYToleranceM:
 				tello.ctrlRy = autoPilotSpeedSlow // half throttle
-			case deltaY < -AutoXYToleranceM:
+			case deltaY < -gains.XYToleranceM:
 				tello.ctrlRy = -autoPilotSpeedSlow // half throttle
 			default:
 				log.Fatalf("Invalid state in AutoFlyToXY() - deltaY=%f", deltaY)
@@ -180,6 +180,20 @@ func TestCalcDeltas(t *testing.T) {
 	log.Printf("dx: %f, dy: %f\n", dx, dy)
 }
 
+func TestIsStable(t *testing.T) {
+	samples := []poseSample{{0, 0, 1, 179}, {0.05, -0.02, 1.05, -179}, {0.08, 0.01, 1.02, 178}}
+	if !isStable(samples) {
+		t.Error("Expected small drift across the yaw wrap-around to be stable")
+	}
+	samples = append(samples, poseSample{0.2, 0, 1, 179})
+	if isStable(samples) {
+		t.Error("Expected 20cm drift to be unstable")
+	}
+	if isStable([]poseSample{{0, 0, 1, 10}, {0, 0, 1, 20}}) {
+		t.Error("Expected 10 degree turn to be unstable")
+	}
+}
+
 func TestAutoFlyToXY(t *testing.T) {
 	drone := new(Tello)
 	log.Printf("Testing version: %s\n", TelloPackageVersion)
@@ -224,3 +238,25 @@ func TestAutoFlyToXY(t *testing.T) {
 	drone.ControlDisconnect()
 	log.Println("Disconnected normally from Tello")
 }
+
+func TestAutoFlyToHeightMaxHeight(t *testing.T) {
+	var drone Tello
+	drone.fd.MaxHeight = 3
+	if _, err := drone.AutoFlyToHeight(40); err == nil {
+		t.Error("Expected target above the drone's maximum height to be rejected")
+	}
+}
+
+func TestSetHomeRefusedDuringAutoflight(t *testing.T) {
+	drone := new(Tello)
+	for _, flag := range []*bool{&drone.autoXY, &drone.autoTraj, &drone.autoFollow, &drone.autoPath} {
+		*flag = true
+		if err := drone.SetHome(); err == nil {
+			t.Error("Expected SetHome to be refused during automatic flight")
+		}
+		*flag = false
+	}
+	if err := drone.SetHome(); err != nil {
+		t.Errorf("Expected SetHome to succeed, got %v", err)
+	}
+}
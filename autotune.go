@@ -0,0 +1,402 @@
+// autotune.go
+
+// This file contains the autopilot gains and the in-flight auto-tuning routine.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+	"time"
+)
+
+const (
+	tuneRelayStick   = autoPilotSpeedSlow // stick deflection used for relay tests
+	tuneCycles       = 4                  // number of full oscillations measured per axis
+	tuneAxisTimeout  = 30 * time.Second   // give up on an axis after this long
+	tuneSettlePeriod = 2 * time.Second    // pause between axes
+	tuneGainFactor   = 0.45               // fraction of the ultimate gain used for position gains
+	tuneXYHysteresis = 0.05               // metres, stops MVO noise from switching the relay
+)
+
+// AutopilotGains holds the controller settings used by the autopilots.
+// The zero value is not useful, start from DefaultAutopilotGains() or the result of AutoTune().
+type AutopilotGains struct {
+	Drone             string  // free-form identifier of the drone these gains were tuned for, eg. its SSID
+	HeightNearDm      int16   // AutoFlyToHeight slows down within this distance in decimetres
+	HeightToleranceDm int16   // AutoFlyToHeight stops within this distance in decimetres
+	YawNearDeg        int16   // AutoTurnToYaw slows down within this angle in degrees
+	YawToleranceDeg   int16   // AutoTurnToYaw stops within this angle in degrees
+	XYNearM           float32 // AutoFlyToXY slows down within this distance in metres
+	XYToleranceM      float32 // AutoFlyToXY stops within this distance in metres
+	TrajXYGain        float32 // horizontal position gain (per second) used by AutoFlyTrajectory
+	TrajHeightGain    float32 // vertical position gain (per second) used by AutoFlyTrajectory
+}
+
+// DefaultAutopilotGains returns the gains used if none have been set.
+func DefaultAutopilotGains() AutopilotGains {
+	return AutopilotGains{
+		HeightNearDm:      4,
+		HeightToleranceDm: 0,
+		YawNearDeg:        10,
+		YawToleranceDeg:   0,
+		XYNearM:           AutoXYNearTargetM,
+		XYToleranceM:      AutoXYToleranceM,
+		TrajXYGain:        AutoTrajPosGain,
+		TrajHeightGain:    AutoTrajPosGain,
+	}
+}
+
+func (g AutopilotGains) validate() error {
+	if g.HeightToleranceDm < 0 || g.HeightNearDm < g.HeightToleranceDm ||
+		g.YawToleranceDeg < 0 || g.YawNearDeg < g.YawToleranceDeg ||
+		g.XYToleranceM < 0 || g.XYNearM < g.XYToleranceM {
+		return errors.New("Autopilot tolerances must not be negative or exceed the slow-down distances")
+	}
+	if g.TrajXYGain <= 0 || g.TrajHeightGain <= 0 {
+		return errors.New("Autopilot trajectory gains must be positive")
+	}
+	return nil
+}
+
+// Save writes the gains to a JSON file so that they can be reloaded for the same drone.
+func (g AutopilotGains) Save(filename string) error {
+	buf, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf, 0644)
+}
+
+// LoadAutopilotGains reads gains previously written by AutopilotGains.Save().
+func LoadAutopilotGains(filename string) (g AutopilotGains, err error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return g, err
+	}
+	if err = json.Unmarshal(buf, &g); err != nil {
+		return g, err
+	}
+	return g, g.validate()
+}
+
+// SetAutopilotGains changes the gains used by the autopilots.
+// Autopilots which are already running pick up the new values on their next iteration.
+func (tello *Tello) SetAutopilotGains(g AutopilotGains) error {
+	if err := g.validate(); err != nil {
+		return err
+	}
+	tello.gainsMu.Lock()
+	tello.gains = &g
+	tello.gainsMu.Unlock()
+	return nil
+}
+
+// GetAutopilotGains returns the gains currently used by the autopilots.
+func (tello *Tello) GetAutopilotGains() AutopilotGains {
+	tello.gainsMu.RLock()
+	defer tello.gainsMu.RUnlock()
+	if tello.gains == nil {
+		return DefaultAutopilotGains()
+	}
+	return *tello.gains
+}
+
+// TuneAxis identifies an axis for AutoTune().
+type TuneAxis int
+
+// Tunable axes...
+const (
+	TuneHeight TuneAxis = iota
+	TuneYaw
+	TuneX // sideways
+	TuneY // forwards/backwards
+)
+
+// AxisTuneResult holds the measurements made on a single axis by a relay test.
+type AxisTuneResult struct {
+	Axis         TuneAxis
+	Amplitude    float64 // half peak-to-peak oscillation in the axis' units (dm, degrees, or m)
+	Period       float64 // oscillation period in seconds
+	UltimateGain float64 // relay-estimated ultimate gain, (m/s)/m for height & XY, stick fraction per degree for yaw
+}
+
+// AutoTuneResult is sent on the channel returned by AutoTune() when tuning ends.
+// If Err is nil then Gains holds the recommended settings, which have not been applied;
+// pass them to SetAutopilotGains() and/or save them with AutopilotGains.Save().
+type AutoTuneResult struct {
+	Gains AutopilotGains
+	Axes  []AxisTuneResult
+	Err   error
+}
+
+// errAutoTuning is returned by the other autopilots while AutoTune() is running.
+var errAutoTuning = errors.New("Cannot start automatic flight while auto-tuning")
+
+// CancelAutoTune stops an in-flight AutoTune.
+// The drone should stop and no gains are recommended.
+func (tello *Tello) CancelAutoTune() {
+	tello.autoTuneMu.Lock()
+	tello.autoTune = false
+	tello.autoTuneMu.Unlock()
+}
+
+// IsAutoTuning tests whether AutoTune is currently running.
+func (tello *Tello) IsAutoTuning() (set bool) {
+	tello.autoTuneMu.RLock()
+	set = tello.autoTune
+	tello.autoTuneMu.RUnlock()
+	return set
+}
+
+// AutoTune performs a relay test on each of the given axes (all axes if none are given)
+// while the drone hovers, and recommends autopilot gains from the measured responses.
+// Each test drives the axis back and forth around its starting point at half stick, so
+// allow at least a metre of clear space around the drone and ensure good light for MVO.
+// No other autopilot should be started while tuning is in progress.
+// The func returns immediately and a Goroutine runs the tests until either they
+// are complete or cancelled via CancelAutoTune().
+// The result is sent on the returned (buffered) channel.
+func (tello *Tello) AutoTune(axes ...TuneAxis) (result chan AutoTuneResult, err error) {
+	if err = tello.checkArmed(); err != nil {
+		return nil, err
+	}
+	if tello.IsAutoTuning() {
+		return nil, errors.New("Already auto-tuning")
+	}
+	if tello.isAutoHeight() || tello.IsAutoTurning() || tello.IsAutoXY() || tello.IsAutoTrajectory() ||
+		tello.IsAutoFollowing() || tello.IsAutoPath() {
+		return nil, errors.New("Cannot auto-tune during other automatic flight")
+	}
+	tello.fdMu.RLock()
+	flying := tello.fd.Flying
+	drone := tello.fd.SSID
+	tello.fdMu.RUnlock()
+	if !flying {
+		return nil, errors.New("Cannot auto-tune unless flying")
+	}
+	if len(axes) == 0 {
+		axes = []TuneAxis{TuneHeight, TuneYaw, TuneX, TuneY}
+	}
+
+	tello.autoTuneMu.Lock()
+	tello.autoTune = true
+	tello.autoTuneMu.Unlock()
+
+	result = make(chan AutoTuneResult, 1) // buffered so send doesn't block
+
+	go func() {
+		res := AutoTuneResult{Gains: tello.GetAutopilotGains()}
+		res.Gains.Drone = drone
+		xyTuned := false
+		for i, axis := range axes {
+			if i > 0 {
+				time.Sleep(tuneSettlePeriod)
+			}
+			atr, err := tello.relayTest(axis)
+			if err != nil {
+				res.Err = err
+				break
+			}
+			res.Axes = append(res.Axes, atr)
+			applyTuneResult(&res.Gains, atr, xyTuned)
+			if axis == TuneX || axis == TuneY {
+				xyTuned = true
+			}
+		}
+		tello.Hover()
+		tello.CancelAutoTune()
+		result <- res
+	}()
+
+	return result, nil
+}
+
+// relayTest oscillates a single axis around its starting point with a bang-bang (relay)
+// controller and measures the resulting limit cycle.
+func (tello *Tello) relayTest(axis TuneAxis) (atr AxisTuneResult, err error) {
+	atr.Axis = axis
+	tello.fdMu.RLock()
+	startHeight := tello.fd.Height
+	startYaw := tello.fd.IMU.Yaw
+	startX, startY := tello.fd.MVO.PositionX, tello.fd.MVO.PositionY
+	tello.fdMu.RUnlock()
+
+	// errorNow returns how far the axis is from its starting point in the axis' units
+	errorNow := func() float64 {
+		tello.fdMu.RLock()
+		defer tello.fdMu.RUnlock()
+		switch axis {
+		case TuneHeight:
+			return float64(tello.fd.Height - startHeight)
+		case TuneYaw:
+			d := tello.fd.IMU.Yaw - startYaw
+			switch {
+			case d > 180:
+				d -= 360
+			case d < -180:
+				d += 360
+			}
+			return float64(d)
+		}
+		// the body-frame offset from the start point
+		dx, dy := calcXYdeltas(tello.fd.IMU.Yaw, startX, startY, tello.fd.MVO.PositionX, tello.fd.MVO.PositionY)
+		if axis == TuneX {
+			return float64(dx)
+		}
+		return float64(dy)
+	}
+	setStick := func(v int16) {
+		tello.ctrlMu.Lock()
+		switch axis {
+		case TuneHeight:
+			tello.ctrlLy = v
+		case TuneYaw:
+			tello.ctrlLx = v
+		case TuneX:
+			tello.ctrlRx = v
+		case TuneY:
+			tello.ctrlRy = v
+		}
+		tello.ctrlMu.Unlock()
+	}
+	defer setStick(0)
+
+	var (
+		times, errs []float64
+		out         int16 = tuneRelayStick
+		crossings   int
+		hyst        float64
+	)
+	if axis == TuneX || axis == TuneY {
+		hyst = tuneXYHysteresis
+	}
+	start := time.Now()
+	setStick(out)
+	for crossings < 2*tuneCycles+2 { // skip the first cycle which starts from rest
+		if !tello.IsAutoTuning() {
+			return atr, errors.New("Auto-tune cancelled")
+		}
+		if time.Since(start) > tuneAxisTimeout {
+			return atr, errors.New("Auto-tune timed out waiting for oscillation")
+		}
+		tello.fdMu.RLock()
+		lowLight := tello.fd.LightStrength == 1
+		tello.fdMu.RUnlock()
+		if lowLight && axis != TuneHeight && axis != TuneYaw {
+			return atr, errors.New("Auto-tune abandoned due to low light")
+		}
+		e := errorNow()
+		times = append(times, time.Since(start).Seconds())
+		errs = append(errs, e)
+		// relay switches direction whenever the axis crosses its starting point
+		switch {
+		case e > hyst && out > 0:
+			out = -tuneRelayStick
+			crossings++
+			setStick(out)
+		case e < -hyst && out < 0:
+			out = tuneRelayStick
+			crossings++
+			setStick(out)
+		}
+		time.Sleep(autopilotPeriodMs * time.Millisecond)
+	}
+
+	var ok bool
+	atr.Amplitude, atr.Period, ok = analyseRelay(times, errs)
+	if !ok {
+		return atr, errors.New("Auto-tune could not measure a stable oscillation")
+	}
+	// ultimate gain from describing-function analysis: Ku = 4d / (pi * a)
+	relaySpeed := float64(tuneRelayStick) / 32767
+	switch axis {
+	case TuneHeight:
+		atr.UltimateGain = 4 * relaySpeed * stickFullClimbMS / (math.Pi * atr.Amplitude / 10)
+	case TuneYaw:
+		atr.UltimateGain = 4 * relaySpeed / (math.Pi * atr.Amplitude) // stick fraction per degree
+	default:
+		atr.UltimateGain = 4 * relaySpeed * stickFullSpeedMS / (math.Pi * atr.Amplitude)
+	}
+	return atr, nil
+}
+
+// analyseRelay measures the amplitude and period of the oscillation in a relay test,
+// ignoring the first cycle.  The period is taken between upward zero-crossings.
+func analyseRelay(times, errs []float64) (amplitude, period float64, ok bool) {
+	var ups []int
+	for i := 1; i < len(errs); i++ {
+		if errs[i-1] < 0 && errs[i] >= 0 {
+			ups = append(ups, i)
+		}
+	}
+	if len(ups) < 3 {
+		return 0, 0, false
+	}
+	cycles := 0
+	for c := 1; c < len(ups)-1; c++ {
+		lo, hi := errs[ups[c]], errs[ups[c]]
+		for _, e := range errs[ups[c]:ups[c+1]] {
+			lo = math.Min(lo, e)
+			hi = math.Max(hi, e)
+		}
+		amplitude += (hi - lo) / 2
+		period += times[ups[c+1]] - times[ups[c]]
+		cycles++
+	}
+	amplitude /= float64(cycles)
+	period /= float64(cycles)
+	return amplitude, period, amplitude > 0 && period > 0
+}
+
+// applyTuneResult updates the gains from the measurements on one axis.
+// The relay amplitude is how far the drone overshoots at half stick, so the stopping
+// tolerance must be at least half that (to avoid hunting) and the slow-down distance
+// about twice that (the overshoot at full stick).
+// The horizontal settings are shared by X and Y, so once either has been tuned
+// the other can only make them more conservative.
+func applyTuneResult(g *AutopilotGains, atr AxisTuneResult, xyTuned bool) {
+	switch atr.Axis {
+	case TuneHeight:
+		g.HeightToleranceDm = int16(math.Ceil(atr.Amplitude / 2))
+		g.HeightNearDm = int16(math.Ceil(2 * atr.Amplitude))
+		g.TrajHeightGain = float32(tuneGainFactor * atr.UltimateGain)
+	case TuneYaw:
+		g.YawToleranceDeg = int16(math.Ceil(atr.Amplitude / 2))
+		g.YawNearDeg = int16(math.Ceil(2 * atr.Amplitude))
+	case TuneX, TuneY:
+		tol := float32(atr.Amplitude / 2)
+		near := float32(2 * atr.Amplitude)
+		gain := float32(tuneGainFactor * atr.UltimateGain)
+		if !xyTuned || tol > g.XYToleranceM {
+			g.XYToleranceM = tol
+		}
+		if !xyTuned || near > g.XYNearM {
+			g.XYNearM = near
+		}
+		if !xyTuned || gain < g.TrajXYGain {
+			g.TrajXYGain = gain
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// autotune_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnalyseRelay(t *testing.T) {
+	var times, errs []float64
+	for i := 0; i < 500; i++ {
+		ts := float64(i) * 0.02
+		times = append(times, ts)
+		errs = append(errs, 0.4*math.Sin(2*math.Pi*ts/2.0)) // 0.4 amplitude, 2s period
+	}
+	amp, period, ok := analyseRelay(times, errs)
+	log.Printf("Amplitude: %f, Period: %f\n", amp, period)
+	if !ok {
+		t.Fatal("Expected successful analysis")
+	}
+	if math.Abs(amp-0.4) > 0.01 || math.Abs(period-2.0) > 0.05 {
+		t.Errorf("Expected amplitude 0.4 & period 2.0, got %f & %f", amp, period)
+	}
+
+	if _, _, ok = analyseRelay(times[:10], errs[:10]); ok {
+		t.Error("Expected failure with too few samples")
+	}
+}
+
+func TestApplyTuneResult(t *testing.T) {
+	g := DefaultAutopilotGains()
+	applyTuneResult(&g, AxisTuneResult{Axis: TuneHeight, Amplitude: 3, UltimateGain: 2}, false)
+	if g.HeightToleranceDm != 2 || g.HeightNearDm != 6 {
+		t.Errorf("Unexpected height gains %v", g)
+	}
+	applyTuneResult(&g, AxisTuneResult{Axis: TuneX, Amplitude: 0.2, UltimateGain: 4}, false)
+	applyTuneResult(&g, AxisTuneResult{Axis: TuneY, Amplitude: 0.1, UltimateGain: 2}, true)
+	if math.Abs(float64(g.XYToleranceM-0.1)) > 0.0001 || math.Abs(float64(g.XYNearM-0.4)) > 0.0001 {
+		t.Errorf("Expected worst-case XY tolerances, got %v", g)
+	}
+	if math.Abs(float64(g.TrajXYGain-0.9)) > 0.0001 {
+		t.Errorf("Expected worst-case XY gain of 0.9, got %f", g.TrajXYGain)
+	}
+	if err := g.validate(); err != nil {
+		t.Errorf("Tuned gains failed validation with %v", err)
+	}
+}
+
+func TestSaveLoadGains(t *testing.T) {
+	g := DefaultAutopilotGains()
+	g.Drone = "TELLO-TEST"
+	g.XYToleranceM = 0.2
+	filename := filepath.Join(t.TempDir(), "gains.json")
+	if err := g.Save(filename); err != nil {
+		t.Fatalf("Save failed with error %v", err)
+	}
+	g2, err := LoadAutopilotGains(filename)
+	if err != nil {
+		t.Fatalf("Load failed with error %v", err)
+	}
+	if g != g2 {
+		t.Errorf("Expected %v, got %v", g, g2)
+	}
+
+	os.WriteFile(filename, []byte(`{"XYNearM": 1, "XYToleranceM": 2}`), 0644)
+	if _, err = LoadAutopilotGains(filename); err == nil {
+		t.Error("Expected invalid gains to be rejected")
+	}
+}
+
+func TestAutoTuneBusy(t *testing.T) {
+	var tello Tello
+	tello.autoFollow = true
+	if _, err := tello.AutoTune(TuneHeight); err == nil {
+		t.Error("Expected auto-tune to be refused while following")
+	}
+	tello.autoFollow = false
+	tello.autoPath = true
+	if _, err := tello.AutoTune(TuneHeight); err == nil {
+		t.Error("Expected auto-tune to be refused while flying a path")
+	}
+}
+
+func TestAutopilotsRefusedWhileTuning(t *testing.T) {
+	var tello Tello
+	tello.autoTune = true
+	if _, err := tello.AutoFlyToHeight(10); err != errAutoTuning {
+		t.Errorf("Expected AutoFlyToHeight to be refused, got %v", err)
+	}
+	if _, err := tello.AutoFlyToXY(1, 1); err != errAutoTuning {
+		t.Errorf("Expected AutoFlyToXY to be refused, got %v", err)
+	}
+	if _, err := tello.AutoTurnToYaw(90); err != errAutoTuning {
+		t.Errorf("Expected AutoTurnToYaw to be refused, got %v", err)
+	}
+	if _, err := tello.AutoFlyPath([]Point3D{{1, 1, 1}}, false); err != errAutoTuning {
+		t.Errorf("Expected AutoFlyPath to be refused, got %v", err)
+	}
+	if _, err := tello.AutoFollow(make(chan Point3D), DefaultFollowConfig()); err != errAutoTuning {
+		t.Errorf("Expected AutoFollow to be refused, got %v", err)
+	}
+	if _, err := tello.AutoNavigateTo(&NavMap{}, Point3D{1, 1, 1}, 0.5); err != errAutoTuning {
+		t.Errorf("Expected AutoNavigateTo to be refused, got %v", err)
+	}
+}
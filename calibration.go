@@ -0,0 +1,135 @@
+// calibration.go
+
+// This file contains the guided IMU and centre-of-gravity calibration workflow.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CalibrationType selects what is to be calibrated.
+type CalibrationType byte
+
+// Calibration types...
+const (
+	CalibrateIMU             CalibrationType = iota // done on the ground, the drone is turned through six orientations
+	CalibrateCentreOfGravity                        // done while hovering in still air
+)
+
+// CalibrationPrompt is called with each instruction the operator must follow,
+// eg. func(step int, msg string) { fmt.Printf("Step %d: %s\n", step, msg) }
+type CalibrationPrompt func(step int, instruction string)
+
+// imuCalibrationSteps are the orientations requested for each ImuCalibrationState value.
+var imuCalibrationSteps = []string{
+	"Place the drone level on a flat surface",
+	"Place the drone upside down",
+	"Stand the drone on its tail, nose up",
+	"Stand the drone on its nose",
+	"Place the drone on its left side",
+	"Place the drone on its right side",
+}
+
+// calibrationPollPeriod is how often ImuCalibrationState is checked.
+const calibrationPollPeriod = 100 * time.Millisecond
+
+// CalibrationTimeout limits how long Calibrate() waits if the supplied context has no deadline.
+const CalibrationTimeout = 3 * time.Minute
+
+// calibrationTracker interprets the sequence of ImuCalibrationState values:
+// non-zero values are in-progress steps, a return to zero means completion,
+// and negative values are assumed to indicate failure.
+type calibrationTracker struct {
+	step    int
+	started bool
+}
+
+func (ct *calibrationTracker) update(state int8) (newStep bool, done bool, err error) {
+	switch {
+	case state < 0:
+		return false, true, fmt.Errorf("Calibration failed with state %d", state)
+	case state == 0:
+		return false, ct.started, nil
+	}
+	ct.started = true
+	if int(state) != ct.step {
+		ct.step = int(state)
+		return true, false, nil
+	}
+	return false, false, nil
+}
+
+// Calibrate starts an IMU or centre-of-gravity calibration and guides the operator through it,
+// calling prompt with each instruction.  It blocks until the calibration completes (returning nil),
+// fails, or ctx ends; if ctx has no deadline then CalibrationTimeout is applied.
+// The drone must be on the ground for IMU calibration and hovering for centre-of-gravity calibration.
+// N.B. Progress is inferred from FlightData.ImuCalibrationState, which is not documented.
+// A negative state is assumed to mean failure, so the error path is a best guess; and it is
+// not known whether centre-of-gravity calibration updates the state at all, in which case
+// Calibrate() returns the context's error when it times out.
+func (tello *Tello) Calibrate(ctx context.Context, ct CalibrationType, prompt CalibrationPrompt) error {
+	if ct != CalibrateIMU && ct != CalibrateCentreOfGravity {
+		return fmt.Errorf("Unknown calibration type %d", ct)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, CalibrationTimeout)
+		defer cancel()
+	}
+	if prompt == nil {
+		prompt = func(int, string) {}
+	}
+	tello.ctrlMu.Lock()
+	err := tello.checkFlightPreconditions(ct == CalibrateCentreOfGravity)
+	if err == nil {
+		tello.ctrlSeq++
+		pkt := newPacket(ptSet, msgDoCalibration, tello.ctrlSeq, 1)
+		pkt.payload[0] = byte(ct)
+		err = tello.writePacket(pkt)
+	}
+	tello.ctrlMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if ct == CalibrateCentreOfGravity {
+		prompt(1, "Keep the drone hovering in still air")
+	}
+
+	var tracker calibrationTracker
+	for {
+		newStep, done, err := tracker.update(tello.GetFlightData().ImuCalibrationState)
+		if err != nil || done {
+			return err
+		}
+		if newStep && ct == CalibrateIMU && tracker.step <= len(imuCalibrationSteps) {
+			prompt(tracker.step, imuCalibrationSteps[tracker.step-1])
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(calibrationPollPeriod):
+		}
+	}
+}
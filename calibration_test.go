@@ -0,0 +1,63 @@
+// calibration_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCalibrationTracker(t *testing.T) {
+	var ct calibrationTracker
+	if newStep, done, _ := ct.update(0); newStep || done {
+		t.Error("Expected nothing before calibration starts")
+	}
+	steps := 0
+	for _, state := range []int8{1, 1, 2, 3, 3, 4, 5, 6} {
+		newStep, done, err := ct.update(state)
+		if done || err != nil {
+			t.Fatalf("Unexpected end of calibration at state %d", state)
+		}
+		if newStep {
+			steps++
+		}
+	}
+	if steps != 6 {
+		t.Errorf("Expected 6 steps, got %d", steps)
+	}
+	if _, done, err := ct.update(0); !done || err != nil {
+		t.Errorf("Expected successful completion, got %v", err)
+	}
+	if _, done, err := ct.update(-1); !done || err == nil {
+		t.Error("Expected failure on negative state")
+	}
+}
+
+func TestCalibrateNotConnected(t *testing.T) {
+	var tello Tello
+	if err := tello.Calibrate(context.Background(), CalibrateIMU, nil); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+	if err := tello.Calibrate(context.Background(), CalibrationType(9), nil); err == nil {
+		t.Error("Expected unknown calibration type to be rejected")
+	}
+}
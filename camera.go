@@ -0,0 +1,153 @@
+// camera.go
+
+// This file contains the camera settings API.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"time"
+)
+
+// cameraReadbackTimeout is how long we wait for the drone to report camera settings.
+const cameraReadbackTimeout = 2 * time.Second
+
+// ExposureLevel is an exposure compensation step.
+type ExposureLevel uint8
+
+// Exposure compensation steps...
+const (
+	ExposureLow    ExposureLevel = iota // darker
+	ExposureNormal                      // the Tello's default
+	ExposureHigh                        // brighter
+)
+
+// CameraSettings holds the camera settings which may be applied and read back together.
+// The Tello provides no known means of setting the JPEG quality, so it is read only.
+type CameraSettings struct {
+	EIS         bool          // electronic image stabilisation
+	Exposure    ExposureLevel // exposure compensation
+	JPEGQuality uint8         // read only
+}
+
+// SetEIS turns electronic image stabilisation on or off, then reads it back to confirm that
+// it has taken effect.  The value is stored in FlightData.EIS.
+func (tello *Tello) SetEIS(on bool) error {
+	var val byte
+	if on {
+		val = 1
+	}
+	return tello.setCameraValue(msgEisSetting, val, func(fd FlightData) bool { return fd.EIS == on })
+}
+
+// GetEIS asks the Tello to send its EIS setting, which is stored in FlightData.EIS when it is received.
+func (tello *Tello) GetEIS() error {
+	return tello.queryCamera(msgEisSetting)
+}
+
+// SetExposure sets the exposure compensation step, then reads it back to confirm that
+// it has taken effect.  The value is stored in FlightData.Exposure.
+func (tello *Tello) SetExposure(level ExposureLevel) error {
+	if level > ExposureHigh {
+		return errors.New("Unknown exposure level")
+	}
+	return tello.setCameraValue(msgExposureVals, byte(level), func(fd FlightData) bool { return fd.Exposure == level })
+}
+
+// GetExposure asks the Tello to send its exposure setting, which is stored in
+// FlightData.Exposure when it is received.
+func (tello *Tello) GetExposure() error {
+	return tello.queryCamera(msgExposureVals)
+}
+
+// GetJPEGQuality asks the Tello to send its JPEG quality setting, which is stored in
+// FlightData.JPEGQuality when it is received.
+func (tello *Tello) GetJPEGQuality() error {
+	return tello.queryCamera(msgQueryJPEGQuality)
+}
+
+// ApplyCameraSettings sets and reads back EIS and exposure in one call, JPEGQuality is ignored.
+func (tello *Tello) ApplyCameraSettings(cs CameraSettings) error {
+	if err := tello.SetEIS(cs.EIS); err != nil {
+		return err
+	}
+	return tello.SetExposure(cs.Exposure)
+}
+
+// GetCameraSettings queries the EIS, exposure and JPEG quality settings from the Tello.
+func (tello *Tello) GetCameraSettings() (cs CameraSettings, err error) {
+	start := time.Now()
+	for _, msgID := range []uint16{msgEisSetting, msgExposureVals, msgQueryJPEGQuality} {
+		if err = tello.queryCamera(msgID); err != nil {
+			return cs, err
+		}
+	}
+	if missing := tello.awaitResponses(start, cameraReadbackTimeout, msgEisSetting, msgExposureVals, msgQueryJPEGQuality); len(missing) > 0 {
+		return cs, errors.New("Timeout waiting for camera settings")
+	}
+	fd := tello.GetFlightData()
+	return CameraSettings{EIS: fd.EIS, Exposure: fd.Exposure, JPEGQuality: fd.JPEGQuality}, nil
+}
+
+// queryCamera sends an empty ptGet packet to request a camera setting.
+func (tello *Tello) queryCamera(msgID uint16) error {
+	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+
+	tello.ctrlSeq++
+	pkt := newPacket(ptGet, msgID, tello.ctrlSeq, 0)
+	return tello.writePacket(pkt)
+}
+
+// setCameraValue sends a one-byte camera setting and then queries it, returning an error if
+// either the acknowledgement or the readback does not arrive, or if matches reports that the
+// value read back into FlightData is not the one that was set.
+// The drone acknowledges the set with the same message ID as the query reply, so we wait for
+// the acknowledgement before querying lest it be mistaken for the readback.
+// N.B. The response listener tells a query reply from an acknowledgement by the reply having
+// at least two payload bytes (result then value); this is inferred, not verified on a drone.
+func (tello *Tello) setCameraValue(msgID uint16, val byte, matches func(FlightData) bool) error {
+	start := time.Now()
+	tello.ctrlMu.Lock()
+	tello.ctrlSeq++
+	pkt := newPacket(ptSet, msgID, tello.ctrlSeq, 1)
+	pkt.payload[0] = val
+	err := tello.writePacket(pkt)
+	tello.ctrlMu.Unlock()
+	if err != nil {
+		return err
+	}
+	if missing := tello.awaitResponses(start, cameraReadbackTimeout, msgID); len(missing) > 0 {
+		return errors.New("Timeout waiting for camera setting acknowledgement")
+	}
+	start = time.Now()
+	if err = tello.queryCamera(msgID); err != nil {
+		return err
+	}
+	if missing := tello.awaitResponses(start, cameraReadbackTimeout, msgID); len(missing) > 0 {
+		return errors.New("Timeout waiting for camera setting readback")
+	}
+	if !matches(tello.GetFlightData()) {
+		return errors.New("Camera setting readback does not match")
+	}
+	return nil
+}
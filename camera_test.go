@@ -0,0 +1,99 @@
+// camera_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCameraSettingsNotConnected(t *testing.T) {
+	var tello Tello
+	if err := tello.SetExposure(ExposureHigh + 1); err == nil {
+		t.Error("Expected unknown exposure level to be rejected")
+	}
+	if err := tello.ApplyCameraSettings(CameraSettings{EIS: true, Exposure: ExposureHigh}); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+	if _, err := tello.GetCameraSettings(); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+	if err := tello.GetEIS(); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+}
+
+// fakeCameraDrone connects tello to a local UDP socket and handles each camera packet received
+// there with reply, which may record the response as the control listener would.
+func fakeCameraDrone(t *testing.T, tello *Tello, reply func(pkt packet)) {
+	drone, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { drone.Close() })
+	if tello.ctrlConn, err = net.DialUDP("udp", nil, drone.LocalAddr().(*net.UDPAddr)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tello.ctrlConn.Close() })
+	tello.ctrlConnected = true
+	go func() {
+		buff := make([]byte, 2048)
+		for {
+			n, err := drone.Read(buff)
+			if err != nil {
+				return
+			}
+			reply(bufferToPacket(buff[:n]))
+		}
+	}()
+}
+
+func TestSetCameraValue(t *testing.T) {
+	var tello Tello
+	exposure := ExposureLow // what the fake drone reports
+	fakeCameraDrone(t, &tello, func(pkt packet) {
+		tello.fdMu.Lock()
+		defer tello.fdMu.Unlock()
+		if tello.rxTimes == nil {
+			tello.rxTimes = map[uint16]time.Time{}
+		}
+		tello.rxTimes[pkt.messageID] = time.Now()
+		if pkt.packetType == ptGet {
+			tello.fd.Exposure = exposure
+		}
+	})
+	if err := tello.SetExposure(ExposureLow); err != nil {
+		t.Errorf("Expected matching readback to succeed, got %v", err)
+	}
+	if err := tello.SetExposure(ExposureHigh); err == nil {
+		t.Error("Expected mismatched readback to be reported")
+	}
+}
+
+func TestSetCameraValueNoAck(t *testing.T) {
+	var tello Tello
+	fakeCameraDrone(t, &tello, func(pkt packet) {})
+	if err := tello.SetEIS(true); err == nil || err.Error() != "Timeout waiting for camera setting acknowledgement" {
+		t.Errorf("Expected acknowledgement timeout, got %v", err)
+	}
+}
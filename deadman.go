@@ -0,0 +1,105 @@
+// deadman.go
+
+// This file contains the stick 'deadman' watchdog.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"time"
+)
+
+// stickAxes flags each of the four stick axes.
+type stickAxes struct {
+	rx, ry, lx, ly bool
+}
+
+// SetStickDeadman starts a watchdog which requires the stick values to be refreshed via
+// UpdateSticks() (or the stick listener, or a macro command such as Forward()) at least
+// once every interval.  If they are not, the sticks are centred, so the drone hovers, and an
+// EvStickDeadman event is raised.  Axes being driven by an autopilot are left alone.
+// An interval of zero disables the watchdog, which is the default.
+func (tello *Tello) SetStickDeadman(interval time.Duration) error {
+	if interval != 0 && interval < 2*keepAlivePeriodMs*time.Millisecond {
+		return errors.New("Stick deadman interval is too short")
+	}
+	tello.ctrlMu.Lock()
+	tello.deadmanInterval = interval
+	tello.stickUpdated = time.Now()
+	tello.deadmanTripped = false
+	tello.ctrlMu.Unlock()
+	return nil
+}
+
+// autoAxes returns the stick axes currently being driven by autopilots.
+func (tello *Tello) autoAxes() (axes stickAxes) {
+	if tello.IsAutoTuning() || tello.IsAutoFollowing() || tello.IsAutoTrajectory() {
+		return stickAxes{true, true, true, true}
+	}
+	if tello.IsAutoXY() {
+		axes.rx, axes.ry = true, true
+	}
+	if tello.IsAutoTurning() {
+		axes.lx = true
+	}
+	if tello.isAutoHeight() {
+		axes.ly = true
+	}
+	return axes
+}
+
+// checkDeadman is called by keepAlive to centre any stale stick values.
+func (tello *Tello) checkDeadman() {
+	tello.ctrlMu.RLock()
+	enabled := tello.deadmanInterval != 0
+	tello.ctrlMu.RUnlock()
+	if !enabled {
+		return
+	}
+	axes := tello.autoAxes() // obtained before ctrlMu to respect the lock ordering
+
+	tello.ctrlMu.Lock()
+	if time.Since(tello.stickUpdated) < tello.deadmanInterval || tello.deadmanTripped {
+		tello.ctrlMu.Unlock()
+		return
+	}
+	stale := time.Since(tello.stickUpdated)
+	centred := false
+	if !axes.rx && tello.ctrlRx != 0 {
+		tello.ctrlRx, centred = 0, true
+	}
+	if !axes.ry && tello.ctrlRy != 0 {
+		tello.ctrlRy, centred = 0, true
+	}
+	if !axes.lx && tello.ctrlLx != 0 {
+		tello.ctrlLx, centred = 0, true
+	}
+	if !axes.ly && tello.ctrlLy != 0 {
+		tello.ctrlLy, centred = 0, true
+	}
+	tello.deadmanTripped = centred
+	tello.ctrlMu.Unlock()
+
+	if centred {
+		tello.publishEvent(EvStickDeadman, "no stick update for %v, sticks centred", stale.Round(time.Millisecond))
+	}
+}
@@ -0,0 +1,53 @@
+// deadman_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckDeadman(t *testing.T) {
+	var tello Tello
+	if err := tello.SetStickDeadman(time.Millisecond); err == nil {
+		t.Error("Expected too short an interval to be rejected")
+	}
+	tello.SetStickDeadman(100 * time.Millisecond)
+	tello.UpdateSticks(StickMessage{Rx: 1000, Ry: 2000, Lx: 0, Ly: 0})
+	tello.checkDeadman()
+	if tello.ctrlRx != 1000 || tello.ctrlRy != 2000 {
+		t.Error("Expected fresh sticks to be left alone")
+	}
+	tello.autoXY = true // pretend the XY autopilot owns Rx & Ry
+	tello.ctrlLy = 3000
+	tello.stickUpdated = time.Now().Add(-time.Second)
+	tello.checkDeadman()
+	if tello.ctrlRx != 1000 || tello.ctrlRy != 2000 || tello.ctrlLy != 0 || !tello.deadmanTripped {
+		t.Errorf("Expected only Ly to be centred, got Rx %d Ry %d Ly %d", tello.ctrlRx, tello.ctrlRy, tello.ctrlLy)
+	}
+	tello.autoXY = false
+	tello.deadmanTripped = false
+	tello.checkDeadman()
+	if tello.ctrlRx != 0 || tello.ctrlRy != 0 {
+		t.Error("Expected stale sticks to be centred")
+	}
+}
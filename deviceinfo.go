@@ -0,0 +1,158 @@
+// deviceinfo.go
+
+// This file contains the device information API.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FirmwareVersion is a parsed Tello version string of the form "01.04.92.01".
+type FirmwareVersion struct {
+	Major, Minor, Patch, Build int
+}
+
+// DeviceInfo describes a particular drone.
+type DeviceInfo struct {
+	Firmware      FirmwareVersion
+	RawVersion    string
+	LoaderVersion string
+	Activated     time.Time // zero if unknown
+	SSID          string
+}
+
+// ParseFirmwareVersion parses a Tello version string, missing trailing components are treated as zero.
+func ParseFirmwareVersion(s string) (v FirmwareVersion, err error) {
+	s = strings.TrimSpace(strings.TrimRight(s, "\x00"))
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 4 {
+		return v, errors.New("Invalid firmware version '" + s + "'")
+	}
+	fields := []*int{&v.Major, &v.Minor, &v.Patch, &v.Build}
+	for i, p := range parts {
+		if *fields[i], err = strconv.Atoi(p); err != nil || *fields[i] < 0 {
+			return FirmwareVersion{}, errors.New("Invalid firmware version '" + s + "'")
+		}
+	}
+	return v, nil
+}
+
+func (v FirmwareVersion) String() string {
+	return fmt.Sprintf("%02d.%02d.%02d.%02d", v.Major, v.Minor, v.Patch, v.Build)
+}
+
+// Compare returns -1, 0 or +1 depending on whether v is older than, the same as, or newer than o.
+func (v FirmwareVersion) Compare(o FirmwareVersion) int {
+	a := []int{v.Major, v.Minor, v.Patch, v.Build}
+	b := []int{o.Major, o.Minor, o.Patch, o.Build}
+	for i := range a {
+		switch {
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	return 0
+}
+
+// AtLeast tests whether v is the same as or newer than o.
+func (v FirmwareVersion) AtLeast(o FirmwareVersion) bool {
+	return v.Compare(o) >= 0
+}
+
+// GetFirmwareVersion returns the parsed firmware version from the most recent GetVersion()
+// response, ok is false if none has been received.  Use it where behaviour must depend
+// upon the firmware, eg...
+//
+//	if v, ok := drone.GetFirmwareVersion(); ok && v.AtLeast(tello.FirmwareVersion{Major: 1, Minor: 4}) {
+func (tello *Tello) GetFirmwareVersion() (v FirmwareVersion, ok bool) {
+	v, err := ParseFirmwareVersion(tello.GetFlightData().Version)
+	return v, err == nil
+}
+
+// GetLoaderVersion asks the Tello to send its boot loader version, which is available via GetDeviceInfo().
+func (tello *Tello) GetLoaderVersion() error {
+	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+
+	tello.ctrlSeq++
+	pkt := newPacket(ptGet, msgQueryLoaderVersion, tello.ctrlSeq, 0)
+	return tello.writePacket(pkt)
+}
+
+// GetActivationTime asks the Tello to send the date it was activated, which is available via GetDeviceInfo().
+func (tello *Tello) GetActivationTime() error {
+	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+
+	tello.ctrlSeq++
+	pkt := newPacket(ptGet, msgQueryActivationTime, tello.ctrlSeq, 0)
+	return tello.writePacket(pkt)
+}
+
+// GetDeviceInfo queries the firmware version, loader version, activation time and SSID,
+// waiting up to timeout for all the responses.
+func (tello *Tello) GetDeviceInfo(timeout time.Duration) (info DeviceInfo, err error) {
+	if !tello.ControlConnected() {
+		return info, errNotConnected
+	}
+	start := time.Now()
+	tello.GetVersion()
+	tello.GetSSID()
+	if err = tello.GetLoaderVersion(); err != nil {
+		return info, err
+	}
+	if err = tello.GetActivationTime(); err != nil {
+		return info, err
+	}
+	if missing := tello.awaitResponses(start, timeout, msgQueryVersion, msgQuerySSID, msgQueryLoaderVersion, msgQueryActivationTime); len(missing) > 0 {
+		return info, fmt.Errorf("Timeout waiting for %d device information responses", len(missing))
+	}
+	tello.fdMu.RLock()
+	info.RawVersion = strings.TrimRight(tello.fd.Version, "\x00")
+	info.SSID = strings.TrimRight(tello.fd.SSID, "\x00")
+	info.LoaderVersion = tello.loaderVersion
+	info.Activated = tello.activated
+	tello.fdMu.RUnlock()
+	info.Firmware, err = ParseFirmwareVersion(info.RawVersion)
+	return info, err
+}
+
+// parseActivationTime decodes a date & time in the same layout as we send in sendDateTime(),
+// returning the zero time if the drone has not been activated.
+func parseActivationTime(pl []byte) time.Time {
+	if len(pl) < 13 {
+		return time.Time{}
+	}
+	field := func(i int) int { return int(pl[i]) | int(pl[i+1])<<8 }
+	year := field(1)
+	if year < 2000 {
+		return time.Time{}
+	}
+	return time.Date(year, time.Month(field(3)), field(5), field(7), field(9), field(11), 0, time.UTC)
+}
@@ -0,0 +1,56 @@
+// deviceinfo_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseFirmwareVersion(t *testing.T) {
+	v, err := ParseFirmwareVersion("01.04.92.01\x00")
+	if err != nil || v != (FirmwareVersion{1, 4, 92, 1}) {
+		t.Errorf("Unexpected parse result %v, %v", v, err)
+	}
+	if v.String() != "01.04.92.01" {
+		t.Errorf("Unexpected string %s", v)
+	}
+	for _, bad := range []string{"", "1.x", "1.2.3.4.5", "1.-2"} {
+		if _, err = ParseFirmwareVersion(bad); err == nil {
+			t.Errorf("Expected '%s' to be rejected", bad)
+		}
+	}
+	short, _ := ParseFirmwareVersion("1.4")
+	if !v.AtLeast(short) || short.AtLeast(v) || v.Compare(v) != 0 {
+		t.Error("Unexpected version comparison result")
+	}
+}
+
+func TestParseActivationTime(t *testing.T) {
+	pl := []byte{0, 0xe2, 0x07, 6, 0, 15, 0, 10, 0, 30, 0, 5, 0}
+	if got, want := parseActivationTime(pl), time.Date(2018, 6, 15, 10, 30, 5, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	if !parseActivationTime(make([]byte, 13)).IsZero() || !parseActivationTime(pl[:5]).IsZero() {
+		t.Error("Expected zero time for unactivated or short payload")
+	}
+}
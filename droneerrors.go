@@ -0,0 +1,125 @@
+// droneerrors.go
+
+// This file decodes the error reports sent by the drone.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// droneErrorHistory is how many DroneErrors we retain.
+const droneErrorHistory = 16
+
+// DroneErrorCode identifies the kind of fault in a drone error report.
+type DroneErrorCode uint16
+
+// droneErrorCodes maps known error codes to descriptions.  The codes are not documented and
+// none have yet been confirmed against a drone, so the table is empty and reports are only
+// described by their code; TODO add codes (eg. motor blocked, IMU error) once identified.
+// Meanwhile applications which have identified codes may add them via SetDroneErrorDescription().
+var (
+	droneErrorCodesMu sync.RWMutex
+	droneErrorCodes   = map[DroneErrorCode]string{}
+)
+
+// SetDroneErrorDescription records a description for an error code, it will be used for
+// subsequent reports with that code.
+func SetDroneErrorDescription(code DroneErrorCode, description string) {
+	droneErrorCodesMu.Lock()
+	droneErrorCodes[code] = description
+	droneErrorCodesMu.Unlock()
+}
+
+// Description returns the description of a known error code, ok is false if it is not known.
+func (code DroneErrorCode) Description() (desc string, ok bool) {
+	droneErrorCodesMu.RLock()
+	desc, ok = droneErrorCodes[code]
+	droneErrorCodesMu.RUnlock()
+	return desc, ok
+}
+
+// DroneError is an error report received from the drone.
+// The Description names the fault if its Code is known, see SetDroneErrorDescription().
+type DroneError struct {
+	Report      int            // 1 or 2, for the two types of error message the drone sends
+	Code        DroneErrorCode // the first two bytes of the report
+	Known       bool           // the Code has a known description
+	Payload     []byte         // the complete report
+	When        time.Time
+	Description string
+}
+
+func (de DroneError) Error() string {
+	return de.Description
+}
+
+// decodeDroneError builds a DroneError from an error message.
+func decodeDroneError(msgID uint16, payload []byte) (de DroneError) {
+	de.Report = 1
+	if msgID == msgError2 {
+		de.Report = 2
+	}
+	de.Payload = append([]byte(nil), payload...)
+	de.When = time.Now()
+	switch {
+	case len(payload) >= 2:
+		de.Code = DroneErrorCode(payload[0]) | DroneErrorCode(payload[1])<<8
+	case len(payload) == 1:
+		de.Code = DroneErrorCode(payload[0])
+	}
+	var desc string
+	if desc, de.Known = de.Code.Description(); de.Known {
+		de.Description = fmt.Sprintf("Drone error report %d: %s (code 0x%04x)", de.Report, desc, uint16(de.Code))
+	} else {
+		de.Description = fmt.Sprintf("Drone error report %d, unknown code 0x%04x", de.Report, uint16(de.Code))
+	}
+	return de
+}
+
+// handleDroneError records an error message from the drone and reports it to the application.
+func (tello *Tello) handleDroneError(pkt packet) {
+	de := decodeDroneError(pkt.messageID, pkt.payload)
+	tello.fdMu.Lock()
+	tello.droneErrors = append(tello.droneErrors, de)
+	if len(tello.droneErrors) > droneErrorHistory {
+		tello.droneErrors = tello.droneErrors[len(tello.droneErrors)-droneErrorHistory:]
+	}
+	tello.fdMu.Unlock()
+	tello.publishEvent(EvDroneError, "%s", de.Description)
+}
+
+// GetDroneErrors returns the most recent error reports from the drone, oldest first.
+func (tello *Tello) GetDroneErrors() []DroneError {
+	tello.fdMu.RLock()
+	defer tello.fdMu.RUnlock()
+	return append([]DroneError(nil), tello.droneErrors...)
+}
+
+// ClearDroneErrors discards the retained error reports.
+func (tello *Tello) ClearDroneErrors() {
+	tello.fdMu.Lock()
+	tello.droneErrors = nil
+	tello.fdMu.Unlock()
+}
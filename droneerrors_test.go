@@ -0,0 +1,61 @@
+// droneerrors_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"testing"
+)
+
+func TestDecodeDroneError(t *testing.T) {
+	de := decodeDroneError(msgError2, []byte{0x34, 0x12, 0x99})
+	if de.Report != 2 || de.Code != 0x1234 || len(de.Payload) != 3 || de.Known {
+		t.Errorf("Unexpected decode %+v", de)
+	}
+	if de.Description != "Drone error report 2, unknown code 0x1234" {
+		t.Errorf("Unexpected description '%s'", de.Error())
+	}
+	SetDroneErrorDescription(0x0005, "test fault")
+	defer func() {
+		droneErrorCodesMu.Lock()
+		delete(droneErrorCodes, 0x0005)
+		droneErrorCodesMu.Unlock()
+	}()
+	de = decodeDroneError(msgError1, []byte{0x05})
+	if de.Report != 1 || de.Code != 5 || !de.Known || de.Description != "Drone error report 1: test fault (code 0x0005)" {
+		t.Errorf("Unexpected decode %+v", de)
+	}
+}
+
+func TestDroneErrorHistory(t *testing.T) {
+	var tello Tello
+	for i := 0; i < droneErrorHistory+4; i++ {
+		tello.handleDroneError(packet{messageID: msgError1, payload: []byte{byte(i), 0}})
+	}
+	errs := tello.GetDroneErrors()
+	if len(errs) != droneErrorHistory || errs[0].Code != 4 {
+		t.Errorf("Expected the last %d errors, got %d starting with code %d", droneErrorHistory, len(errs), errs[0].Code)
+	}
+	tello.ClearDroneErrors()
+	if len(tello.GetDroneErrors()) != 0 {
+		t.Error("Expected errors to be cleared")
+	}
+}
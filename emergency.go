@@ -0,0 +1,137 @@
+// emergency.go
+
+// This file contains the emergency motor stop.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// emergencyStopHold is how long the motor-stop stick combination is held.
+const emergencyStopHold = 2 * time.Second
+
+// errEmergencyStopped is returned by flight commands after an emergency stop.
+var errEmergencyStopped = errors.New("Flight commands are refused after an emergency stop until Rearm() is called")
+
+// EmergencyStop immediately stops the motors by sending the motor-stop stick combination
+// (both sticks fully down and inwards) for a couple of seconds.  The drone WILL fall out of the sky.
+// On the ground the same combination starts the motors, so if the drone is not flying
+// the sticks are simply centred.
+// All autopilots and the stick listener are stopped, and further flight commands are refused
+// until Rearm() is called.
+func (tello *Tello) EmergencyStop() {
+	tello.fdMu.RLock()
+	flying := tello.fd.Flying
+	tello.fdMu.RUnlock()
+	tello.ctrlMu.Lock()
+	tello.ctrlEmergency = true
+	if flying {
+		tello.ctrlEmergencyUntil = time.Now().Add(emergencyStopHold)
+	} else {
+		tello.ctrlEmergencyUntil = time.Time{}
+	}
+	tello.ctrlLx = 0
+	tello.ctrlLy = 0
+	tello.ctrlRx = 0
+	tello.ctrlRy = 0
+	tello.endSmartVideo(errEmergencyStopped)
+	tello.ctrlMu.Unlock()
+	if tello.ControlConnected() {
+		tello.sendStickUpdate() // don't wait for the next keepalive
+	}
+	tello.publishEvent(EvEmergencyStop, "motors stopped")
+	tello.StopStickListener()
+	tello.cancelAllAutopilots()
+}
+
+// IsEmergencyStopped tests whether an emergency stop has occurred and not been cleared by Rearm().
+func (tello *Tello) IsEmergencyStopped() (stopped bool) {
+	tello.ctrlMu.RLock()
+	stopped = tello.ctrlEmergency
+	tello.ctrlMu.RUnlock()
+	return stopped
+}
+
+// Rearm clears an emergency stop so that flight commands are accepted again.
+// The sticks are centred.
+func (tello *Tello) Rearm() {
+	tello.ctrlMu.Lock()
+	tello.ctrlEmergency = false
+	tello.ctrlLx = 0
+	tello.ctrlLy = 0
+	tello.ctrlRx = 0
+	tello.ctrlRy = 0
+	tello.ctrlMu.Unlock()
+}
+
+// EmergencyStopOn calls EmergencyStop() every time a value is received on the trigger channel,
+// which might be fed by a dedicated hotkey.  Close the channel to stop watching it.
+func (tello *Tello) EmergencyStopOn(trigger <-chan bool) {
+	go func() {
+		for range trigger {
+			tello.EmergencyStop()
+		}
+	}()
+}
+
+// EmergencyStopOnSignal calls EmergencyStop() when any of the given OS signals is received.
+// Call the returned func to stop watching for them.
+func (tello *Tello) EmergencyStopOnSignal(sigs ...os.Signal) (stop func()) {
+	sigChan := make(chan os.Signal, 1)
+	trigger := make(chan bool)
+	signal.Notify(sigChan, sigs...)
+	tello.EmergencyStopOn(trigger)
+	go func() {
+		for range sigChan {
+			trigger <- true
+		}
+		close(trigger)
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigChan)
+			close(sigChan)
+		})
+	}
+}
+
+// emergencySticks returns the sticks to send while emergency stopped, it must be called with ctrlMu held.
+func (tello *Tello) emergencySticks() (rx, ry, lx, ly int16) {
+	if time.Now().Before(tello.ctrlEmergencyUntil) {
+		return -32768, -32768, 32767, -32768
+	}
+	return 0, 0, 0, 0
+}
+
+// checkArmed returns an error if flight commands are currently refused.
+func (tello *Tello) checkArmed() error {
+	if tello.IsEmergencyStopped() {
+		return errEmergencyStopped
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// emergency_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEmergencyStop(t *testing.T) {
+	var tello Tello
+	tello.fd.Flying = true
+	tello.UpdateSticks(StickMessage{Rx: 1000, Ry: 2000, Lx: 0, Ly: 0})
+	tello.EmergencyStop()
+	if !tello.IsEmergencyStopped() {
+		t.Fatal("Expected to be emergency stopped")
+	}
+	if rx, ry, lx, ly := tello.emergencySticks(); rx != -32768 || ry != -32768 || lx != 32767 || ly != -32768 {
+		t.Errorf("Expected motor-stop sticks, got %d %d %d %d", rx, ry, lx, ly)
+	}
+	tello.UpdateSticks(StickMessage{Rx: 5000, Ry: 0, Lx: 0, Ly: 0})
+	if tello.ctrlRx == 5000 {
+		t.Error("Expected stick update to be ignored")
+	}
+	if _, err := tello.AutoFlyToHeight(10); err != errEmergencyStopped {
+		t.Errorf("Expected autopilot to be refused, got %v", err)
+	}
+	tello.ctrlEmergencyUntil = time.Now()
+	if rx, ry, lx, ly := tello.emergencySticks(); rx != 0 || ry != 0 || lx != 0 || ly != 0 {
+		t.Error("Expected centred sticks once the hold has expired")
+	}
+	tello.Rearm()
+	if tello.IsEmergencyStopped() || tello.ctrlRx != 0 || tello.ctrlRy != 0 {
+		t.Error("Expected Rearm to clear the stop and centre the sticks")
+	}
+}
+
+func TestEmergencyStopOn(t *testing.T) {
+	var tello Tello
+	trigger := make(chan bool)
+	tello.EmergencyStopOn(trigger)
+	trigger <- true
+	close(trigger)
+	for i := 0; i < 50 && !tello.IsEmergencyStopped(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !tello.IsEmergencyStopped() {
+		t.Error("Expected trigger channel to cause an emergency stop")
+	}
+}
+
+func TestEmergencyStopOnGround(t *testing.T) {
+	var tello Tello
+	tello.UpdateSticks(StickMessage{Rx: 1000, Ry: 2000, Lx: 0, Ly: 0})
+	tello.EmergencyStop()
+	if !tello.IsEmergencyStopped() {
+		t.Fatal("Expected to be emergency stopped")
+	}
+	if rx, ry, lx, ly := tello.emergencySticks(); rx != 0 || ry != 0 || lx != 0 || ly != 0 {
+		t.Errorf("Expected centred sticks on the ground, got %d %d %d %d", rx, ry, lx, ly)
+	}
+	if tello.ctrlRx != 0 || tello.ctrlRy != 0 {
+		t.Error("Expected the sticks to be centred")
+	}
+}
+
+func TestEmergencyStopOnSignalStop(t *testing.T) {
+	var tello Tello
+	stop := tello.EmergencyStopOnSignal(os.Interrupt)
+	stop()
+	stop() // must not panic
+}
@@ -0,0 +1,117 @@
+// events.go
+
+// This file contains the notification of noteworthy events to the application.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const eventChanSize = 16
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+// Event types...
+const (
+	EvGeofenceBreach EventType = iota // the drone has left the geofence
+	EvGeofenceClear                   // the drone is back inside the geofence
+	EvFailsafe                        // the failsafe manager has fired, see also LastFailsafe()
+	EvReturnNow                       // the battery is only just sufficient to return home, see EnableSmartReturn()
+	EvStickDeadman                    // the sticks were not refreshed in time and have been centred, see SetStickDeadman()
+	EvEmergencyStop                   // EmergencyStop() has been called
+	EvPhaseChange                     // the flight phase has changed, see GetFlightPhase()
+	EvDroneError                      // the drone has reported an error, see GetDroneErrors()
+)
+
+var eventTypeNames = map[EventType]string{
+	EvGeofenceBreach: "Geofence Breach",
+	EvGeofenceClear:  "Geofence Clear",
+	EvFailsafe:       "Failsafe",
+	EvReturnNow:      "Return Now",
+	EvStickDeadman:   "Stick Deadman",
+	EvEmergencyStop:  "Emergency Stop",
+	EvPhaseChange:    "Phase Change",
+	EvDroneError:     "Drone Error",
+}
+
+func (et EventType) String() string {
+	if name, ok := eventTypeNames[et]; ok {
+		return name
+	}
+	return fmt.Sprintf("Event %d", int(et))
+}
+
+// Event is a noteworthy occurrence reported to the application via ListenEvents().
+type Event struct {
+	Type    EventType
+	When    time.Time
+	Message string
+}
+
+// ListenEvents returns a channel on which Events will be sent, and a function to stop listening.
+// The channel is buffered, but events are dropped rather than blocking the package
+// if the listener does not keep up.
+func (tello *Tello) ListenEvents() (chan Event, func()) {
+	tello.eventsMu.Lock()
+	defer tello.eventsMu.Unlock()
+	if tello.eventListeners == nil {
+		tello.eventListeners = map[chan Event]chan Event{}
+	}
+	res := make(chan Event, eventChanSize)
+	tello.eventListeners[res] = res
+	return res, func() {
+		tello.eventsMu.Lock()
+		defer tello.eventsMu.Unlock()
+		if _, present := tello.eventListeners[res]; present {
+			delete(tello.eventListeners, res)
+			close(res)
+		}
+	}
+}
+
+// publishEvent logs an event and sends it to all the event listeners without blocking.
+func (tello *Tello) publishEvent(et EventType, format string, args ...interface{}) {
+	ev := Event{Type: et, When: time.Now(), Message: fmt.Sprintf(format, args...)}
+	log.Printf("%s: %s\n", ev.Type, ev.Message)
+	tello.eventsMu.Lock()
+	for l := range tello.eventListeners {
+		select {
+		case l <- ev:
+		default:
+		}
+	}
+	tello.eventsMu.Unlock()
+}
+
+// closeEventListeners closes all the event listener channels.
+func (tello *Tello) closeEventListeners() {
+	tello.eventsMu.Lock()
+	for l := range tello.eventListeners {
+		delete(tello.eventListeners, l)
+		close(l)
+	}
+	tello.eventsMu.Unlock()
+}
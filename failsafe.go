@@ -0,0 +1,290 @@
+// failsafe.go
+
+// This file contains the failsafe manager which reacts to link loss, low battery etc.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// failsafeStaleAfter is how long a message type may be absent before we consider it missing,
+// the grace period in the policy is then applied on top of this.
+const failsafeStaleAfter = 500 * time.Millisecond
+
+// FailsafeCondition identifies a condition monitored by the failsafe manager.
+type FailsafeCondition int
+
+// Failsafe conditions...
+const (
+	FsLinkLoss        FailsafeCondition = iota // no light strength messages (our 'still here' signal) from the drone
+	FsStaleTelemetry                           // no flight status messages from the drone
+	FsBatteryLow                               // the drone reports BatteryLow
+	FsBatteryCritical                          // the drone reports BatteryCritical
+	FsImuError                                 // the drone reports a bad ImuState
+	FsLowLight                                 // LightStrength is too low for MVO positioning
+)
+
+var failsafeConditionNames = map[FailsafeCondition]string{
+	FsLinkLoss:        "Link Loss",
+	FsStaleTelemetry:  "Stale Telemetry",
+	FsBatteryLow:      "Battery Low",
+	FsBatteryCritical: "Battery Critical",
+	FsImuError:        "IMU Error",
+	FsLowLight:        "Low Light",
+}
+
+func (fc FailsafeCondition) String() string {
+	if name, ok := failsafeConditionNames[fc]; ok {
+		return name
+	}
+	return fmt.Sprintf("Condition %d", int(fc))
+}
+
+// FailsafeAction is what the failsafe manager does when a condition fires.
+// Every action other than FsIgnore first cancels any running autopilots.
+type FailsafeAction int
+
+// Failsafe actions...
+const (
+	FsIgnore     FailsafeAction = iota // take no action
+	FsHover                            // stop and hover
+	FsLand                             // land where we are
+	FsReturnHome                       // fly back to the home point and land there, or just land if it is not set
+)
+
+var failsafeActionNames = map[FailsafeAction]string{
+	FsIgnore:     "Ignore",
+	FsHover:      "Hover",
+	FsLand:       "Land",
+	FsReturnHome: "Return Home",
+}
+
+// severity orders the actions so that the strongest may be chosen when several fire together.
+func (fa FailsafeAction) severity() int {
+	switch fa {
+	case FsHover:
+		return 1
+	case FsReturnHome:
+		return 2
+	case FsLand:
+		return 3
+	}
+	return 0
+}
+
+func (fa FailsafeAction) String() string {
+	if name, ok := failsafeActionNames[fa]; ok {
+		return name
+	}
+	return fmt.Sprintf("Action %d", int(fa))
+}
+
+// FailsafeRule says what to do when a condition has persisted for the grace period.
+type FailsafeRule struct {
+	Action FailsafeAction
+	Grace  time.Duration
+}
+
+// FailsafePolicy holds the rule for each condition, conditions which are absent are ignored.
+type FailsafePolicy map[FailsafeCondition]FailsafeRule
+
+// FailsafeRecord records why the failsafe manager fired.
+type FailsafeRecord struct {
+	Condition FailsafeCondition
+	Action    FailsafeAction
+	When      time.Time
+	Detail    string
+}
+
+// failsafeState tracks the conditions between checks.
+type failsafeState struct {
+	since  map[FailsafeCondition]time.Time // when each currently-active condition was first seen
+	fired  map[FailsafeCondition]bool      // conditions which have fired and not yet cleared
+	last   *FailsafeRecord
+	acting FailsafeAction // strongest action taken this flight, weaker ones do not override it
+}
+
+// DefaultFailsafePolicy returns a suggested policy.
+func DefaultFailsafePolicy() FailsafePolicy {
+	return FailsafePolicy{
+		FsLinkLoss:        {Action: FsHover, Grace: time.Second},
+		FsStaleTelemetry:  {Action: FsHover, Grace: time.Second},
+		FsBatteryLow:      {Action: FsReturnHome, Grace: 5 * time.Second},
+		FsBatteryCritical: {Action: FsLand, Grace: time.Second},
+		FsImuError:        {Action: FsLand, Grace: time.Second},
+		FsLowLight:        {Action: FsHover, Grace: 2 * time.Second},
+	}
+}
+
+// SetFailsafePolicy starts the failsafe manager with the given policy, which is checked
+// every time stick values are sent to the drone.  The manager only acts while the drone is flying,
+// and each condition fires once, then not again until it has cleared.
+// When several conditions fire together only the strongest action is taken (Land, then
+// Return Home, then Hover), and a weaker action never overrides a stronger one taken earlier
+// in the same flight.
+// Pass a nil policy to stop the manager.
+func (tello *Tello) SetFailsafePolicy(policy FailsafePolicy) error {
+	for cond, rule := range policy {
+		if _, ok := failsafeConditionNames[cond]; !ok {
+			return errors.New("Unknown failsafe condition")
+		}
+		if _, ok := failsafeActionNames[rule.Action]; !ok {
+			return errors.New("Unknown failsafe action")
+		}
+		if rule.Grace < 0 {
+			return errors.New("Failsafe grace period must not be negative")
+		}
+		if cond == FsLinkLoss && failsafeStaleAfter+rule.Grace >= lightStrengthTimeout {
+			return errors.New("Link loss grace period must expire before the connection is dropped")
+		}
+	}
+	copied := FailsafePolicy{}
+	for cond, rule := range policy {
+		copied[cond] = rule
+	}
+	tello.failsafeMu.Lock()
+	if policy == nil {
+		tello.failsafePolicy = nil
+	} else {
+		tello.failsafePolicy = copied
+	}
+	tello.failsafe.since = map[FailsafeCondition]time.Time{}
+	tello.failsafe.fired = map[FailsafeCondition]bool{}
+	tello.failsafeMu.Unlock()
+	return nil
+}
+
+// LastFailsafe returns the most recent occasion the failsafe manager fired, if any.
+func (tello *Tello) LastFailsafe() (rec FailsafeRecord, fired bool) {
+	tello.failsafeMu.RLock()
+	defer tello.failsafeMu.RUnlock()
+	if tello.failsafe.last == nil {
+		return rec, false
+	}
+	return *tello.failsafe.last, true
+}
+
+// activeFailsafeConditions returns the conditions which currently apply, with a description of each.
+func (tello *Tello) activeFailsafeConditions(now time.Time) map[FailsafeCondition]string {
+	active := map[FailsafeCondition]string{}
+	tello.fdMu.RLock()
+	defer tello.fdMu.RUnlock()
+	if ls := tello.fd.LightStrengthUpdated; !ls.IsZero() && now.Sub(ls) > failsafeStaleAfter {
+		active[FsLinkLoss] = fmt.Sprintf("no contact for %v", now.Sub(ls).Round(time.Millisecond))
+	}
+	if fs := tello.rxTimes[msgFlightStatus]; !fs.IsZero() && now.Sub(fs) > failsafeStaleAfter {
+		active[FsStaleTelemetry] = fmt.Sprintf("no flight status for %v", now.Sub(fs).Round(time.Millisecond))
+	}
+	if tello.rxTimes[msgFlightStatus].IsZero() {
+		return active // the remaining conditions depend on flight status
+	}
+	if tello.fd.BatteryLow {
+		active[FsBatteryLow] = fmt.Sprintf("battery at %d%%", tello.fd.BatteryPercentage)
+	}
+	if tello.fd.BatteryCritical {
+		active[FsBatteryCritical] = fmt.Sprintf("battery at %d%%", tello.fd.BatteryPercentage)
+	}
+	if !tello.fd.ImuState {
+		active[FsImuError] = "IMU state reported as bad"
+	}
+	if tello.fd.LightStrength == 1 {
+		active[FsLowLight] = "light strength too low"
+	}
+	return active
+}
+
+// checkFailsafe is called by keepAlive to evaluate the failsafe policy.
+func (tello *Tello) checkFailsafe() {
+	tello.failsafeMu.RLock()
+	enabled := tello.failsafePolicy != nil
+	tello.failsafeMu.RUnlock()
+	if !enabled {
+		return
+	}
+	now := time.Now()
+	active := tello.activeFailsafeConditions(now)
+	tello.fdMu.RLock()
+	flying := tello.fd.Flying
+	tello.fdMu.RUnlock()
+
+	var fire []FailsafeRecord
+	var action FailsafeAction
+	tello.failsafeMu.Lock()
+	if !flying {
+		tello.failsafe.acting = FsIgnore
+	}
+	for cond := range failsafeConditionNames {
+		detail, isActive := active[cond]
+		if !isActive {
+			delete(tello.failsafe.since, cond)
+			delete(tello.failsafe.fired, cond)
+			continue
+		}
+		if _, seen := tello.failsafe.since[cond]; !seen {
+			tello.failsafe.since[cond] = now
+		}
+		rule, hasRule := tello.failsafePolicy[cond]
+		if !hasRule || rule.Action == FsIgnore || !flying || tello.failsafe.fired[cond] ||
+			now.Sub(tello.failsafe.since[cond]) < rule.Grace {
+			continue
+		}
+		tello.failsafe.fired[cond] = true
+		rec := FailsafeRecord{Condition: cond, Action: rule.Action, When: now, Detail: detail}
+		tello.failsafe.last = &rec
+		fire = append(fire, rec)
+		if rec.Action.severity() > action.severity() {
+			action = rec.Action
+		}
+	}
+	// only the strongest action is taken, and only if it is stronger than any already in progress
+	start := action.severity() > tello.failsafe.acting.severity()
+	if start {
+		tello.failsafe.acting = action
+	}
+	tello.failsafeMu.Unlock()
+
+	for _, rec := range fire {
+		tello.publishEvent(EvFailsafe, "%s (%s) - %s", rec.Condition, rec.Detail, rec.Action)
+	}
+	if start {
+		go tello.failsafeAction(action)
+	}
+}
+
+func (tello *Tello) failsafeAction(action FailsafeAction) {
+	tello.cancelAllAutopilots()
+	tello.Hover()
+	switch action {
+	case FsLand:
+		tello.Land()
+	case FsReturnHome:
+		if _, err := tello.AutoReturnHome(true); err != nil {
+			tello.failsafeMu.Lock()
+			tello.failsafe.acting = FsLand
+			tello.failsafeMu.Unlock()
+			tello.Land()
+		}
+	}
+}
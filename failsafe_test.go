@@ -0,0 +1,142 @@
+// failsafe_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetFailsafePolicy(t *testing.T) {
+	var tello Tello
+	if err := tello.SetFailsafePolicy(DefaultFailsafePolicy()); err != nil {
+		t.Errorf("Default policy rejected with %v", err)
+	}
+	if err := tello.SetFailsafePolicy(FailsafePolicy{FsLinkLoss: {Action: FsHover, Grace: 10 * time.Second}}); err == nil {
+		t.Error("Expected over-long link loss grace to be rejected")
+	}
+	if err := tello.SetFailsafePolicy(FailsafePolicy{FsLowLight: {Action: FailsafeAction(99)}}); err == nil {
+		t.Error("Expected unknown action to be rejected")
+	}
+}
+
+func TestActiveFailsafeConditions(t *testing.T) {
+	var tello Tello
+	now := time.Now()
+	if active := tello.activeFailsafeConditions(now); len(active) != 0 {
+		t.Errorf("Expected no conditions before any data received, got %v", active)
+	}
+	tello.fd.LightStrengthUpdated = now.Add(-2 * time.Second)
+	tello.rxTimes = map[uint16]time.Time{msgFlightStatus: now}
+	tello.fd.ImuState = true
+	tello.fd.BatteryCritical = true
+	tello.fd.LightStrength = 1
+	active := tello.activeFailsafeConditions(now)
+	for _, cond := range []FailsafeCondition{FsLinkLoss, FsBatteryCritical, FsLowLight} {
+		if _, ok := active[cond]; !ok {
+			t.Errorf("Expected %s to be active", cond)
+		}
+	}
+	if len(active) != 3 {
+		t.Errorf("Expected 3 active conditions, got %v", active)
+	}
+}
+
+func TestCheckFailsafeGrace(t *testing.T) {
+	var tello Tello
+	events, stop := tello.ListenEvents()
+	defer stop()
+	tello.SetFailsafePolicy(FailsafePolicy{FsLowLight: {Action: FsHover, Grace: time.Second}})
+	tello.rxTimes = map[uint16]time.Time{msgFlightStatus: time.Now()}
+	tello.fd.ImuState = true
+	tello.fd.Flying = true
+	tello.fd.LightStrength = 1
+	tello.fd.LightStrengthUpdated = time.Now()
+	tello.checkFailsafe()
+	if _, fired := tello.LastFailsafe(); fired {
+		t.Error("Expected failsafe not to fire within grace period")
+	}
+	if _, seen := tello.failsafe.since[FsLowLight]; !seen {
+		t.Fatal("Expected low light condition to be tracked")
+	}
+
+	// backdate the condition so that its grace period has expired
+	tello.failsafe.since[FsLowLight] = time.Now().Add(-2 * time.Second)
+	tello.checkFailsafe()
+	rec, fired := tello.LastFailsafe()
+	if !fired || rec.Condition != FsLowLight || rec.Action != FsHover {
+		t.Fatalf("Expected low light hover failsafe, got %v %v", rec, fired)
+	}
+	if ev := <-events; ev.Type != EvFailsafe {
+		t.Errorf("Expected failsafe event, got %v", ev)
+	}
+	// it must only fire once while the condition persists
+	tello.checkFailsafe()
+	select {
+	case ev := <-events:
+		t.Errorf("Expected failsafe to fire only once, got %v", ev)
+	default:
+	}
+
+	tello.fd.LightStrength = 2
+	tello.checkFailsafe()
+	if _, seen := tello.failsafe.since[FsLowLight]; seen {
+		t.Error("Expected low light condition to be cleared")
+	}
+}
+
+func TestFailsafeStrongestAction(t *testing.T) {
+	var tello Tello
+	tello.SetFailsafePolicy(FailsafePolicy{
+		FsBatteryLow:      {Action: FsReturnHome},
+		FsBatteryCritical: {Action: FsLand},
+		FsLowLight:        {Action: FsHover},
+	})
+	tello.rxTimes = map[uint16]time.Time{msgFlightStatus: time.Now()}
+	tello.fd.ImuState = true
+	tello.fd.Flying = true
+	tello.fd.LightStrengthUpdated = time.Now()
+	tello.fd.BatteryLow = true
+	tello.fd.BatteryCritical = true
+	tello.checkFailsafe()
+	tello.failsafeMu.RLock()
+	acting := tello.failsafe.acting
+	tello.failsafeMu.RUnlock()
+	if acting != FsLand {
+		t.Errorf("Expected Land to be chosen over Return Home, got %s", acting)
+	}
+
+	// a later, weaker condition must not override the landing
+	tello.fdMu.Lock()
+	tello.fd.LightStrength = 1
+	tello.fdMu.Unlock()
+	tello.checkFailsafe()
+	tello.failsafeMu.RLock()
+	acting = tello.failsafe.acting
+	tello.failsafeMu.RUnlock()
+	if acting != FsLand {
+		t.Errorf("Expected Hover not to override Land, got %s", acting)
+	}
+	if rec, _ := tello.LastFailsafe(); rec.Condition != FsLowLight {
+		t.Errorf("Expected low light to be recorded, got %v", rec)
+	}
+}
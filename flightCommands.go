@@ -23,10 +23,66 @@
 
 package tello
 
-// TakeOff sends a normal takeoff request to the Tello.
+import (
+	"errors"
+	"time"
+)
+
+// FlipMinBatteryPct is the lowest battery percentage at which the Tello will perform a flip.
+const FlipMinBatteryPct = 50
+
+// Common command precondition errors...
+var (
+	errNotConnected = errors.New("Not connected to the Tello")
+	errNotFlying    = errors.New("The Tello is not flying")
+	errFlying       = errors.New("The Tello is already flying")
+	errLanding      = errors.New("The Tello is already landing")
+)
+
+// writePacket sends a packet on the control connection, the caller must hold ctrlMu.
+func (tello *Tello) writePacket(pkt packet) error {
+	if !tello.ctrlConnected {
+		return errNotConnected
+	}
+	_, err := tello.ctrlConn.Write(packetToBuffer(pkt))
+	return err
+}
+
+// checkFlightPreconditions verifies the emergency stop, flying state and landing state, the caller must hold ctrlMu.
+func (tello *Tello) checkFlightPreconditions(mustBeFlying bool) error {
+	if !tello.ctrlConnected {
+		return errNotConnected
+	}
+	if tello.ctrlEmergency {
+		return errEmergencyStopped
+	}
+	tello.fdMu.RLock()
+	flying := tello.fd.Flying
+	tello.fdMu.RUnlock()
+	switch {
+	case mustBeFlying && !flying:
+		return errNotFlying
+	case !mustBeFlying && flying:
+		return errFlying
+	case mustBeFlying && tello.ctrlLanding:
+		return errLanding
+	}
+	return nil
+}
+
+// TakeOff sends a normal takeoff request to the Tello, which must be on the ground.
 // Any previously set origin is invalidated.
-func (tello *Tello) TakeOff() {
+// It is refused after an emergency stop until Rearm() is called, or if a required
+// pre-flight check has not been passed (see SetPreflightRequired()).
+func (tello *Tello) TakeOff() error {
+	if err := tello.checkPreflight(); err != nil {
+		return err
+	}
 	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+	if err := tello.checkFlightPreconditions(false); err != nil {
+		return err
+	}
 
 	tello.autoXYMu.Lock()
 	tello.homeValid = false // origin is invalidated until flying and reset
@@ -34,15 +90,30 @@ func (tello *Tello) TakeOff() {
 
 	tello.ctrlSeq++
 	pkt := newPacket(ptSet, msgDoTakeoff, tello.ctrlSeq, 0)
-	tello.ctrlConn.Write(packetToBuffer(pkt))
-
-	tello.ctrlMu.Unlock()
+	if err := tello.writePacket(pkt); err != nil {
+		return err
+	}
+	tello.ctrlTakeoffAt = time.Now()
+	return nil
 }
 
-// ThrowTakeOff initiates a 'throw and go' launch.
+// ThrowTakeOff initiates a 'throw and go' launch, the Tello must not already be flying.
 // Any previously set origin is invalidated.
-func (tello *Tello) ThrowTakeOff() {
+// It is refused after an emergency stop until Rearm() is called, if a required
+// pre-flight check has not been passed (see SetPreflightRequired()), or if blocked by
+// the current restrictions.
+func (tello *Tello) ThrowTakeOff() error {
+	if err := tello.checkRestricted(func(r Restrictions) bool { return r.BlockThrowTakeOff }, "Throw takeoff"); err != nil {
+		return err
+	}
+	if err := tello.checkPreflight(); err != nil {
+		return err
+	}
 	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+	if err := tello.checkFlightPreconditions(false); err != nil {
+		return err
+	}
 
 	tello.autoXYMu.Lock()
 	tello.homeValid = false // origin is invalidated until flying and reset
@@ -50,92 +121,181 @@ func (tello *Tello) ThrowTakeOff() {
 
 	tello.ctrlSeq++
 	pkt := newPacket(ptGet, msgDoThrowTakeoff, tello.ctrlSeq, 0)
-	tello.ctrlConn.Write(packetToBuffer(pkt))
-
-	tello.ctrlMu.Unlock()
+	if err := tello.writePacket(pkt); err != nil {
+		return err
+	}
+	tello.ctrlThrowAt = time.Now()
+	return nil
 }
 
-// Land sends a normal Land request to the Tello.
-func (tello *Tello) Land() {
+// Land sends a normal Land request to the Tello, which must be flying and not already landing.
+// Land is permitted after an emergency stop.
+func (tello *Tello) Land() error {
 	tello.ctrlMu.Lock()
 	defer tello.ctrlMu.Unlock()
+	if err := tello.checkLandPreconditions(); err != nil {
+		return err
+	}
 
 	tello.ctrlSeq++
 	pkt := newPacket(ptSet, msgDoLand, tello.ctrlSeq, 1)
 	pkt.payload[0] = 0 // see StopLanding() for use of this field
-	tello.ctrlConn.Write(packetToBuffer(pkt))
+	if err := tello.writePacket(pkt); err != nil {
+		return err
+	}
+	tello.ctrlLanding = true
+	tello.endSmartVideo(errors.New("Smart video action ended by landing"))
+	return nil
 }
 
 // StopLanding cancels a land command.
-func (tello *Tello) StopLanding() {
+func (tello *Tello) StopLanding() error {
 	tello.ctrlMu.Lock()
 	defer tello.ctrlMu.Unlock()
+	if !tello.ctrlLanding {
+		return errors.New("The Tello is not landing")
+	}
 
 	tello.ctrlSeq++
 	pkt := newPacket(ptSet, msgDoLand, tello.ctrlSeq, 1)
 	pkt.payload[0] = 1
-	tello.ctrlConn.Write(packetToBuffer(pkt))
+	if err := tello.writePacket(pkt); err != nil {
+		return err
+	}
+	tello.ctrlLanding = false
+	tello.ctrlPalmLanding = false
+	return nil
 }
 
-// PalmLand initiates a Palm Landing.
-func (tello *Tello) PalmLand() {
+// PalmLand initiates a Palm Landing, the Tello must be flying and not already landing.
+func (tello *Tello) PalmLand() error {
 	tello.ctrlMu.Lock()
 	defer tello.ctrlMu.Unlock()
+	if err := tello.checkLandPreconditions(); err != nil {
+		return err
+	}
 
 	tello.ctrlSeq++
 	pkt := newPacket(ptSet, msgDoPalmLand, tello.ctrlSeq, 1)
 	pkt.payload[0] = 0
-	tello.ctrlConn.Write(packetToBuffer(pkt))
+	if err := tello.writePacket(pkt); err != nil {
+		return err
+	}
+	tello.ctrlLanding = true
+	tello.ctrlPalmLanding = true
+	tello.endSmartVideo(errors.New("Smart video action ended by landing"))
+	return nil
 }
 
-// Bounce toggles the bouncing mode of the Tello.
-func (tello *Tello) Bounce() {
+// checkLandPreconditions is like checkFlightPreconditions(true) but ignores any emergency stop,
+// the caller must hold ctrlMu.
+func (tello *Tello) checkLandPreconditions() error {
+	if !tello.ctrlConnected {
+		return errNotConnected
+	}
+	tello.fdMu.RLock()
+	flying := tello.fd.Flying
+	tello.fdMu.RUnlock()
+	switch {
+	case !flying:
+		return errNotFlying
+	case tello.ctrlLanding:
+		return errLanding
+	}
+	return nil
+}
+
+// Bounce toggles the bouncing mode of the Tello, which must be flying.
+// It is refused after an emergency stop until Rearm() is called, or if blocked by the current restrictions.
+func (tello *Tello) Bounce() error {
+	if err := tello.checkRestricted(func(r Restrictions) bool { return r.BlockBounce }, "Bounce"); err != nil {
+		return err
+	}
 	tello.ctrlMu.Lock()
 	defer tello.ctrlMu.Unlock()
+	if err := tello.checkFlightPreconditions(true); err != nil {
+		return err
+	}
 
 	tello.ctrlSeq++
 	pkt := newPacket(ptSet, msgDoBounce, tello.ctrlSeq, 1)
 	if tello.ctrlBouncing {
 		pkt.payload[0] = 0x31
-		tello.ctrlBouncing = false
 	} else {
 		pkt.payload[0] = 0x30
-		tello.ctrlBouncing = true
 	}
-	tello.ctrlConn.Write(packetToBuffer(pkt))
+	if err := tello.writePacket(pkt); err != nil {
+		return err
+	}
+	tello.ctrlBouncing = !tello.ctrlBouncing
+	return nil
 }
 
-// Flip sends a flip flight command to the Tello.
-func (tello *Tello) Flip(dir FlipType) {
+// Flip sends a flip flight command to the Tello, which must be flying with at least FlipMinBatteryPct battery.
+// It is refused after an emergency stop until Rearm() is called, or if blocked by the current restrictions.
+func (tello *Tello) Flip(dir FlipType) error {
+	if err := tello.checkRestricted(func(r Restrictions) bool { return r.BlockFlips }, "Flipping"); err != nil {
+		return err
+	}
 	tello.ctrlMu.Lock()
 	defer tello.ctrlMu.Unlock()
+	if err := tello.checkFlightPreconditions(true); err != nil {
+		return err
+	}
+	tello.fdMu.RLock()
+	battery := tello.fd.BatteryPercentage
+	tello.fdMu.RUnlock()
+	if battery < FlipMinBatteryPct {
+		return errors.New("Battery too low to flip")
+	}
 
 	tello.ctrlSeq++
 	pkt := newPacket(ptFlip, msgDoFlip, tello.ctrlSeq, 1)
 	pkt.payload[0] = byte(dir)
-	tello.ctrlConn.Write(packetToBuffer(pkt))
+	return tello.writePacket(pkt)
 }
 
-// StartSmartVideo begins a preprogrammed 'smart video' flight action.
-func (tello *Tello) StartSmartVideo(cmd SvCmd) {
+// StartSmartVideo begins a preprogrammed 'smart video' flight action, the Tello must be flying.
+// It is refused after an emergency stop until Rearm() is called, or if an action is already running.
+// The caller may optionally listen on the 'done' channel for the result when the action ends,
+// whether it completes, fails to start, or is ended by StopSmartVideo(), Land(), PalmLand(),
+// EmergencyStop(), ControlDisconnect() or loss of contact with the drone.
+func (tello *Tello) StartSmartVideo(cmd SvCmd) (done <-chan SmartVideoResult, err error) {
 	tello.ctrlMu.Lock()
 	defer tello.ctrlMu.Unlock()
+	if err = tello.checkFlightPreconditions(true); err != nil {
+		return nil, err
+	}
+	if tello.smartVideo != nil {
+		return nil, errors.New("A smart video action is already running")
+	}
 
 	tello.ctrlSeq++
 	pkt := newPacket(ptSet, msgDoSmartVideo, tello.ctrlSeq, 1)
 	pkt.payload[0] = byte(cmd) | 0x01
-	tello.ctrlConn.Write(packetToBuffer(pkt))
+	if err = tello.writePacket(pkt); err != nil {
+		return nil, err
+	}
+	sv := &smartVideoState{cmd: cmd, done: make(chan SmartVideoResult, 1)} // buffered so send doesn't block
+	tello.smartVideo = sv
+	time.AfterFunc(svStartTimeout, func() { tello.checkSmartVideoStarted(sv) })
+	return sv.done, nil
 }
 
-// StopSmartVideo begins a preprogrammed 'smart video' flight action.
-func (tello *Tello) StopSmartVideo(cmd SvCmd) {
+// StopSmartVideo ends a preprogrammed 'smart video' flight action.
+// The result of any action started by StartSmartVideo() is reported with an error.
+func (tello *Tello) StopSmartVideo(cmd SvCmd) error {
 	tello.ctrlMu.Lock()
 	defer tello.ctrlMu.Unlock()
 
 	tello.ctrlSeq++
 	pkt := newPacket(ptSet, msgDoSmartVideo, tello.ctrlSeq, 1)
 	pkt.payload[0] = byte(cmd)
-	tello.ctrlConn.Write(packetToBuffer(pkt))
+	if err := tello.writePacket(pkt); err != nil {
+		return err
+	}
+	tello.endSmartVideo(errors.New("Smart video action stopped"))
+	return nil
 }
 
 // *** The following are 'macro' commands which are here purely
@@ -239,46 +399,53 @@ func (tello *Tello) CounterClockwise(pct int) {
 }
 
 // SetSportsMode sets the sports mode of flight to the given value.
-func (tello *Tello) SetSportsMode(sports bool) {
+// Enabling sports mode is refused if blocked by the current restrictions.
+func (tello *Tello) SetSportsMode(sports bool) error {
+	if sports {
+		if err := tello.checkRestricted(func(r Restrictions) bool { return r.BlockSportsMode }, "Sports mode"); err != nil {
+			return err
+		}
+	}
 	tello.ctrlMu.Lock()
 	tello.ctrlSportsMode = sports
 	tello.ctrlMu.Unlock()
+	return nil
 }
 
 // SetFastMode sets the 'fast' or 'sports' mode of flight.
-func (tello *Tello) SetFastMode() {
-	tello.SetSportsMode(true)
+func (tello *Tello) SetFastMode() error {
+	return tello.SetSportsMode(true)
 }
 
 // SetSlowMode sets the 'slow' or 'normal' mode of flight.
-func (tello *Tello) SetSlowMode() {
-	tello.SetSportsMode(false)
+func (tello *Tello) SetSlowMode() error {
+	return tello.SetSportsMode(false)
 }
 
 // Flips...
 
 // BackFlip - flip backwards.
-func (tello *Tello) BackFlip() { tello.Flip(FlipBackward) }
+func (tello *Tello) BackFlip() error { return tello.Flip(FlipBackward) }
 
 // BackLeftFlip - flip backwards and to the left.
-func (tello *Tello) BackLeftFlip() { tello.Flip(FlipBackwardLeft) }
+func (tello *Tello) BackLeftFlip() error { return tello.Flip(FlipBackwardLeft) }
 
 // BackRightFlip - flip backwards and to the right.
-func (tello *Tello) BackRightFlip() { tello.Flip(FlipBackwardRight) }
+func (tello *Tello) BackRightFlip() error { return tello.Flip(FlipBackwardRight) }
 
 // ForwardFlip - flip forwards.
-func (tello *Tello) ForwardFlip() { tello.Flip(FlipForward) }
+func (tello *Tello) ForwardFlip() error { return tello.Flip(FlipForward) }
 
 // ForwardRightFlip - flip forwardsand to the right.
-func (tello *Tello) ForwardRightFlip() { tello.Flip(FlipForwardRight) }
+func (tello *Tello) ForwardRightFlip() error { return tello.Flip(FlipForwardRight) }
 
 // ForwardLeftFlip - flip forward and to the left.
-func (tello *Tello) ForwardLeftFlip() { tello.Flip(FlipForwardLeft) }
+func (tello *Tello) ForwardLeftFlip() error { return tello.Flip(FlipForwardLeft) }
 
 // LeftFlip - flip to the left.
-func (tello *Tello) LeftFlip() { tello.Flip(FlipLeft) }
+func (tello *Tello) LeftFlip() error { return tello.Flip(FlipLeft) }
 
 // RightFlip - flip to the right.
-func (tello *Tello) RightFlip() { tello.Flip(FlipRight) }
+func (tello *Tello) RightFlip() error { return tello.Flip(FlipRight) }
 
 // *** End of 'macro' commands ***
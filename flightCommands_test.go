@@ -0,0 +1,60 @@
+// flightCommands_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"testing"
+)
+
+func TestFlightPreconditions(t *testing.T) {
+	var tello Tello
+	if err := tello.TakeOff(); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+	tello.ctrlConnected = true
+	if err := tello.checkFlightPreconditions(true); err != errNotFlying {
+		t.Errorf("Expected not flying error, got %v", err)
+	}
+	if err := tello.Flip(FlipForward); err != errNotFlying {
+		t.Errorf("Expected flip to need flight, got %v", err)
+	}
+	tello.fd.Flying = true
+	if err := tello.checkFlightPreconditions(false); err != errFlying {
+		t.Errorf("Expected already flying error, got %v", err)
+	}
+	tello.fd.BatteryPercentage = FlipMinBatteryPct - 1
+	if err := tello.Flip(FlipForward); err == nil {
+		t.Error("Expected flip to be refused on low battery")
+	}
+	tello.ctrlLanding = true
+	if err := tello.Land(); err != errLanding {
+		t.Errorf("Expected already landing error, got %v", err)
+	}
+	tello.ctrlLanding = false
+	tello.ctrlEmergency = true
+	if err := tello.checkFlightPreconditions(true); err != errEmergencyStopped {
+		t.Errorf("Expected emergency stop error, got %v", err)
+	}
+	if err := tello.checkLandPreconditions(); err != nil {
+		t.Errorf("Expected landing to be permitted after emergency stop, got %v", err)
+	}
+}
@@ -23,6 +23,7 @@ package tello
 
 import (
 	"math"
+	"time"
 )
 
 func (tello *Tello) ackLogHeader(id []byte) {
@@ -81,6 +82,7 @@ func (tello *Tello) parseLogPacket(data []byte) {
 				tello.fd.MVO.PositionY = bytesToFloat32(xorBuf[offset+8 : offset+13])
 				tello.fd.MVO.PositionX = bytesToFloat32(xorBuf[offset+12 : offset+17])
 				tello.fd.MVO.PositionZ = bytesToFloat32(xorBuf[offset+16 : offset+21])
+				tello.mvoPosUpdated = time.Now()
 			}
 			tello.fdMu.Unlock()
 		case logRecIMU:
@@ -0,0 +1,302 @@
+// follow.go
+
+// This file contains the autopilot mode which follows an externally-tracked target.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"log"
+	"math"
+	"time"
+)
+
+const (
+	followVelSmoothing = 0.5 // weight given to the newest velocity estimate of the target
+	followYawFullDeg   = 45  // yaw error at which we turn at full rate when facing the target
+)
+
+// FollowConfig holds the settings for AutoFollow().
+type FollowConfig struct {
+	StandoffM   float32       // horizontal distance to keep from the target in metres
+	HeightM     float32       // height to fly above the target's Z in metres
+	MaxSpeedMS  float32       // horizontal speed limit in m/s
+	MaxClimbMS  float32       // vertical speed limit in m/s
+	FeedTimeout time.Duration // following stops if no target position is received for this long
+	LeadTime    time.Duration // how far ahead to predict the target's motion (to cover feed latency)
+	FaceTarget  bool          // turn to keep the target straight ahead
+}
+
+// DefaultFollowConfig returns a cautious FollowConfig suitable for a walking-pace target.
+func DefaultFollowConfig() FollowConfig {
+	return FollowConfig{
+		StandoffM:   2.0,
+		HeightM:     1.0,
+		MaxSpeedMS:  1.5,
+		MaxClimbMS:  0.5,
+		FeedTimeout: time.Second,
+		LeadTime:    200 * time.Millisecond,
+		FaceTarget:  true,
+	}
+}
+
+// targetPredictor estimates a target's velocity from successive fixes and extrapolates its position.
+type targetPredictor struct {
+	pos, vel Point3D
+	when     time.Time
+}
+
+func (tp *targetPredictor) update(pos Point3D, when time.Time) {
+	if !tp.when.IsZero() {
+		if dt := float32(when.Sub(tp.when).Seconds()); dt > 0 {
+			a := float32(followVelSmoothing)
+			tp.vel.X = a*(pos.X-tp.pos.X)/dt + (1-a)*tp.vel.X
+			tp.vel.Y = a*(pos.Y-tp.pos.Y)/dt + (1-a)*tp.vel.Y
+			tp.vel.Z = a*(pos.Z-tp.pos.Z)/dt + (1-a)*tp.vel.Z
+		}
+	}
+	tp.pos = pos
+	tp.when = when
+}
+
+func (tp *targetPredictor) predict(when time.Time) Point3D {
+	dt := float32(when.Sub(tp.when).Seconds())
+	return Point3D{X: tp.pos.X + tp.vel.X*dt, Y: tp.pos.Y + tp.vel.Y*dt, Z: tp.pos.Z + tp.vel.Z*dt}
+}
+
+// followSetpoint returns where the drone should be to keep the standoff distance from the target,
+// staying on the same bearing from the target that it is on now.
+func followSetpoint(target, drone Point3D, cfg FollowConfig) Point3D {
+	dx, dy := drone.X-target.X, drone.Y-target.Y
+	dist := float32(math.Hypot(float64(dx), float64(dy)))
+	if dist < 0.01 { // directly over the target, back off 'south'
+		dx, dy, dist = 0, -1, 1
+	}
+	return Point3D{
+		X: target.X + dx/dist*cfg.StandoffM,
+		Y: target.Y + dy/dist*cfg.StandoffM,
+		Z: target.Z + cfg.HeightM,
+	}
+}
+
+// fenceFollow replaces the horizontal and/or vertical parts of the setpoint with those of the
+// hold position if the setpoint lies outside any geofence, reporting which were replaced.
+func (tello *Tello) fenceFollow(setpoint, hold Point3D) (sp Point3D, holdXY, holdZ bool) {
+	sp = setpoint
+	if !tello.fenceAllowsXY(setpoint.X, setpoint.Y) {
+		sp.X, sp.Y, holdXY = hold.X, hold.Y, true
+	}
+	if !tello.fenceAllowsHeight(setpoint.Z) {
+		sp.Z, holdZ = hold.Z, true
+	}
+	return sp, holdXY, holdZ
+}
+
+// CancelAutoFollow stops any in-flight AutoFollow navigation.
+// The drone should stop.
+func (tello *Tello) CancelAutoFollow() {
+	tello.autoFollowMu.Lock()
+	tello.autoFollow = false
+	tello.autoFollowMu.Unlock()
+}
+
+// IsAutoFollowing tests whether we are currently following a target.
+func (tello *Tello) IsAutoFollowing() (set bool) {
+	tello.autoFollowMu.RLock()
+	set = tello.autoFollow
+	tello.autoFollowMu.RUnlock()
+	return set
+}
+
+// AutoFollow follows a target whose positions, in the home frame, are sent on the targets channel
+// by an external tracker (eg. motion capture, UWB beacons, or a vision process).  The home point
+// must have been previously set.
+// The drone keeps cfg.StandoffM metres away from, and cfg.HeightM metres above, the predicted
+// target position, optionally turning to face it.
+// The func returns immediately and a Goroutine handles the navigation until either it is
+// cancelled via CancelAutoFollow(), the targets channel is closed, or no target position
+// arrives for cfg.FeedTimeout; the drone then stops and hovers.
+// If a geofence is set then, rather than following the target to a setpoint outside the fence,
+// the drone actively holds the position (horizontally and/or vertically) that it had reached
+// when the setpoint left the fence.
+// The caller may optionally listen on the 'done' channel for a signal that following has ended.
+func (tello *Tello) AutoFollow(targets <-chan Point3D, cfg FollowConfig) (done chan bool, err error) {
+	if err = tello.checkArmed(); err != nil {
+		return nil, err
+	}
+	if tello.IsAutoTuning() {
+		return nil, errAutoTuning
+	}
+	if targets == nil {
+		return nil, errors.New("No target channel supplied")
+	}
+	if cfg.StandoffM < 0 || cfg.HeightM*10 > AutoHeightLimitDm {
+		return nil, errors.New("Invalid follow standoff or height")
+	}
+	if cfg.MaxSpeedMS <= 0 || cfg.MaxSpeedMS > stickFullSpeedMS || cfg.MaxClimbMS <= 0 || cfg.MaxClimbMS > stickFullClimbMS ||
+		cfg.FeedTimeout <= 0 {
+		return nil, errors.New("Invalid follow speed limit or timeout")
+	}
+	if tello.IsAutoFollowing() {
+		return nil, errors.New("Already following a target")
+	}
+	if tello.IsAutoXY() || tello.isAutoHeight() || tello.IsAutoTurning() || tello.IsAutoTrajectory() || tello.IsAutoPath() {
+		return nil, errors.New("Cannot follow a target during other automatic flight")
+	}
+	if !tello.IsHomeSet() {
+		return nil, errors.New("Cannot AutoFly as home point has not be set (or is invalid)")
+	}
+
+	tello.autoFollowMu.Lock()
+	tello.autoFollow = true
+	tello.autoFollowMu.Unlock()
+
+	done = make(chan bool, 1) // buffered so send doesn't block
+
+	go func() {
+		var predictor targetPredictor
+		lastFix := time.Now()
+		var hold Point3D
+		var holdXY, holdZ bool
+		for {
+			// has following been cancelled?
+			if !tello.IsAutoFollowing() {
+				tello.Hover()
+				tello.sendStickUpdate()
+				done <- true
+				return
+			}
+
+			// collect any fixes which have arrived
+		drain:
+			for {
+				select {
+				case p, ok := <-targets:
+					if !ok {
+						log.Println("Target feed closed, stopping following")
+						tello.CancelAutoFollow()
+						break drain
+					}
+					if p.X > AutoXYLimitM || p.Y > AutoXYLimitM || p.X < -AutoXYLimitM || p.Y < -AutoXYLimitM {
+						log.Printf("Ignoring target position %v beyond navigation limit\n", p)
+						continue
+					}
+					lastFix = time.Now()
+					predictor.update(p, lastFix)
+				default:
+					break drain
+				}
+			}
+			if !tello.IsAutoFollowing() {
+				continue
+			}
+			if time.Since(lastFix) > cfg.FeedTimeout {
+				log.Println("Target feed has gone quiet, stopping following")
+				tello.CancelAutoFollow()
+				continue
+			}
+			if predictor.when.IsZero() { // no fix yet
+				time.Sleep(autopilotPeriodMs * time.Millisecond)
+				continue
+			}
+
+			tello.fdMu.RLock()
+			lowLight := tello.fd.LightStrength == 1
+			tello.fdMu.RUnlock()
+			if lowLight {
+				log.Println("Cancelling following due to low light")
+				tello.CancelAutoFollow()
+				continue
+			}
+
+			curX, curY, curZ, curYaw := tello.homeRelativePos()
+			target := predictor.predict(time.Now().Add(cfg.LeadTime))
+			setpoint := followSetpoint(target, Point3D{X: curX, Y: curY, Z: curZ}, cfg)
+			gains := tello.GetAutopilotGains()
+
+			// remember where to hold if the setpoint leaves the geofence
+			if !holdXY {
+				hold.X, hold.Y = curX, curY
+			}
+			if !holdZ {
+				hold.Z = curZ
+			}
+			wasHeld := holdXY || holdZ
+			setpoint, holdXY, holdZ = tello.fenceFollow(setpoint, hold)
+			if held := holdXY || holdZ; held != wasHeld {
+				if held {
+					log.Printf("Follow setpoint is outside the geofence, holding position %v\n", setpoint)
+				} else {
+					log.Println("Follow setpoint is back inside the geofence")
+				}
+			}
+
+			// feed forward the target's motion and correct towards the setpoint
+			ff := predictor.vel
+			if holdXY {
+				ff.X, ff.Y = 0, 0
+			}
+			if holdZ {
+				ff.Z = 0
+			}
+			vx := ff.X + gains.TrajXYGain*(setpoint.X-curX)
+			vy := ff.Y + gains.TrajXYGain*(setpoint.Y-curY)
+			if speed := float32(math.Hypot(float64(vx), float64(vy))); speed > cfg.MaxSpeedMS {
+				vx *= cfg.MaxSpeedMS / speed
+				vy *= cfg.MaxSpeedMS / speed
+			}
+			vz := ff.Z + gains.TrajHeightGain*(setpoint.Z-curZ)
+			switch {
+			case vz > cfg.MaxClimbMS:
+				vz = cfg.MaxClimbMS
+			case vz < -cfg.MaxClimbMS:
+				vz = -cfg.MaxClimbMS
+			}
+			bodyX, bodyY := calcXYdeltas(curYaw, 0, 0, vx, vy)
+
+			var yawStick int16
+			if cfg.FaceTarget {
+				yawErr := headingTo(target.X-curX, target.Y-curY) - curYaw
+				switch {
+				case yawErr > 180:
+					yawErr -= 360
+				case yawErr < -180:
+					yawErr += 360
+				}
+				if int16Abs(yawErr) > gains.YawToleranceDeg {
+					yawStick = speedToStick(float32(yawErr), followYawFullDeg)
+				}
+			}
+
+			tello.ctrlMu.Lock()
+			tello.ctrlRx = speedToStick(bodyX, stickFullSpeedMS)
+			tello.ctrlRy = speedToStick(bodyY, stickFullSpeedMS)
+			tello.ctrlLy = speedToStick(vz, stickFullClimbMS)
+			tello.ctrlLx = yawStick
+			tello.ctrlMu.Unlock()
+
+			time.Sleep(autopilotPeriodMs * time.Millisecond)
+		}
+	}()
+
+	return done, nil
+}
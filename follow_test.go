@@ -0,0 +1,85 @@
+// follow_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTargetPredictor(t *testing.T) {
+	var tp targetPredictor
+	start := time.Now()
+	for i := 0; i <= 20; i++ {
+		when := start.Add(time.Duration(i) * 100 * time.Millisecond)
+		tp.update(Point3D{X: float32(i) * 0.1, Y: 1, Z: 0}, when) // 1 m/s along X
+	}
+	if math.Abs(float64(tp.vel.X-1)) > 0.01 || math.Abs(float64(tp.vel.Y)) > 0.01 {
+		t.Errorf("Expected velocity (1, 0), got %v", tp.vel)
+	}
+	p := tp.predict(tp.when.Add(500 * time.Millisecond))
+	if math.Abs(float64(p.X-2.5)) > 0.01 {
+		t.Errorf("Expected predicted X of 2.5, got %f", p.X)
+	}
+}
+
+func TestFollowSetpoint(t *testing.T) {
+	cfg := DefaultFollowConfig()
+	sp := followSetpoint(Point3D{X: 0, Y: 0, Z: 0.5}, Point3D{X: 4, Y: 0, Z: 1}, cfg)
+	if math.Abs(float64(sp.X-cfg.StandoffM)) > 0.0001 || sp.Y != 0 || sp.Z != 0.5+cfg.HeightM {
+		t.Errorf("Unexpected setpoint %v", sp)
+	}
+	// directly overhead
+	sp = followSetpoint(Point3D{X: 1, Y: 1}, Point3D{X: 1, Y: 1, Z: 1}, cfg)
+	if d := math.Hypot(float64(sp.X-1), float64(sp.Y-1)); math.Abs(d-float64(cfg.StandoffM)) > 0.0001 {
+		t.Errorf("Expected standoff of %f, got %f", cfg.StandoffM, d)
+	}
+}
+
+func TestFenceFollow(t *testing.T) {
+	var tello Tello
+	hold := Point3D{X: 1, Y: 2, Z: 1.5}
+	if sp, hXY, hZ := tello.fenceFollow(Point3D{X: 10, Y: 0, Z: 5}, hold); hXY || hZ || sp != (Point3D{X: 10, Y: 0, Z: 5}) {
+		t.Errorf("Expected setpoint unchanged without a fence, got %v %v %v", sp, hXY, hZ)
+	}
+	tello.SetGeofence(Geofence{MaxRadiusM: 5, CeilingM: 3})
+	if sp, hXY, hZ := tello.fenceFollow(Point3D{X: 10, Y: 0, Z: 2}, hold); !hXY || hZ || sp != (Point3D{X: 1, Y: 2, Z: 2}) {
+		t.Errorf("Expected horizontal hold, got %v %v %v", sp, hXY, hZ)
+	}
+	if sp, hXY, hZ := tello.fenceFollow(Point3D{X: 1, Y: 0, Z: 4}, hold); hXY || !hZ || sp != (Point3D{X: 1, Y: 0, Z: 1.5}) {
+		t.Errorf("Expected vertical hold, got %v %v %v", sp, hXY, hZ)
+	}
+}
+
+func TestAutoFollowClimbLimit(t *testing.T) {
+	var tello Tello
+	cfg := DefaultFollowConfig()
+	cfg.MaxClimbMS = 0
+	if _, err := tello.AutoFollow(make(chan Point3D), cfg); err == nil {
+		t.Error("Expected zero climb limit to be rejected")
+	}
+	cfg.MaxClimbMS = stickFullClimbMS + 1
+	if _, err := tello.AutoFollow(make(chan Point3D), cfg); err == nil {
+		t.Error("Expected excessive climb limit to be rejected")
+	}
+}
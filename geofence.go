@@ -0,0 +1,311 @@
+// geofence.go
+
+// This file contains the geofence which keeps the drone within a defined volume.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"math"
+)
+
+// FenceAction is what the geofence does when the drone breaches it.
+// Whatever the action, stick input which would take the drone further outside the fence
+// is blocked for as long as the drone remains outside.
+type FenceAction int
+
+// Geofence breach actions...
+const (
+	FenceBlock      FenceAction = iota // only block outward stick input
+	FencePushBack                      // cancel any autopilots and fly back inside
+	FenceHover                         // cancel any autopilots and hover
+	FenceReturnHome                    // cancel any autopilots, regain the permitted height band and fly back to the home point
+	FenceLand                          // cancel any autopilots and land
+)
+
+// Geofence defines the volume in which the drone may fly.  All limits are in the home frame
+// and zero values mean that limit is not applied.  The horizontal limits can only be checked
+// while the home point is set.
+type Geofence struct {
+	Polygon    []Point2D // horizontal boundary, see also BoxPolygon()
+	MaxRadiusM float32   // maximum horizontal distance from home
+	CeilingM   float32   // maximum height
+	FloorM     float32   // minimum height (only checked while flying)
+	Action     FenceAction
+}
+
+// fenceRecoveryMarginM is how far inside the ceiling or floor FenceReturnHome brings the
+// drone before flying home.
+const fenceRecoveryMarginM = 0.5
+
+// fenceState is the result of the most recent geofence check.
+type fenceState struct {
+	outside      bool
+	outX, outY   float32 // unit vector pointing outwards from the horizontal boundary in the home frame, or zero
+	aboveCeiling bool
+	belowFloor   bool
+	yaw          int16
+	pushBack     bool
+}
+
+// BoxPolygon returns a rectangle aligned with the home frame axes, for use as a geofence
+// polygon or an obstacle.
+func BoxPolygon(minX, minY, maxX, maxY float32) []Point2D {
+	return []Point2D{{minX, minY}, {maxX, minY}, {maxX, maxY}, {minX, maxY}}
+}
+
+// SetGeofence applies a geofence which is checked every time stick values are sent to the drone.
+// Auto... navigation to targets outside the fence is refused while it is set.
+func (tello *Tello) SetGeofence(fence Geofence) error {
+	if len(fence.Polygon) > 0 && len(fence.Polygon) < 3 {
+		return errors.New("A geofence polygon must have at least 3 vertices")
+	}
+	if fence.MaxRadiusM < 0 || fence.CeilingM < 0 || fence.FloorM < 0 {
+		return errors.New("Geofence limits must not be negative")
+	}
+	if fence.CeilingM > 0 && fence.FloorM >= fence.CeilingM {
+		return errors.New("Geofence floor must be below the ceiling")
+	}
+	if r, set := tello.GetRestrictions(); set && r.CeilingM > 0 && fence.FloorM >= r.CeilingM {
+		return errors.New("Geofence floor must be below the restriction ceiling")
+	}
+	if fence.Action < FenceBlock || fence.Action > FenceLand {
+		return errors.New("Unknown geofence action")
+	}
+	fence.Polygon = append([]Point2D(nil), fence.Polygon...)
+	tello.fenceMu.Lock()
+	tello.fence = &fence
+	tello.fenceState = fenceState{}
+	tello.fenceMu.Unlock()
+	return nil
+}
+
+// ClearGeofence removes any geofence.
+func (tello *Tello) ClearGeofence() {
+	tello.fenceMu.Lock()
+	tello.fence = nil
+	tello.fenceState = fenceState{}
+	tello.fenceMu.Unlock()
+}
+
+// GetGeofence returns the current geofence and whether one is set.
+func (tello *Tello) GetGeofence() (fence Geofence, set bool) {
+	tello.fenceMu.RLock()
+	defer tello.fenceMu.RUnlock()
+	if tello.fence == nil {
+		return fence, false
+	}
+	return *tello.fence, true
+}
+
+// IsOutsideGeofence tests whether the drone was outside the geofence when last checked.
+func (tello *Tello) IsOutsideGeofence() (outside bool) {
+	tello.fenceMu.RLock()
+	outside = tello.fenceState.outside
+	tello.fenceMu.RUnlock()
+	return outside
+}
+
+// ContainsXY tests whether a horizontal position in the home frame is within the geofence.
+func (fence Geofence) ContainsXY(x, y float32) bool {
+	if fence.MaxRadiusM > 0 && x*x+y*y > fence.MaxRadiusM*fence.MaxRadiusM {
+		return false
+	}
+	if len(fence.Polygon) > 0 && !pointInPolygon(Point2D{x, y}, fence.Polygon) {
+		return false
+	}
+	return true
+}
+
+// ContainsHeight tests whether a height in metres is within the geofence.
+func (fence Geofence) ContainsHeight(z float32) bool {
+	return !(fence.CeilingM > 0 && z > fence.CeilingM) && !(fence.FloorM > 0 && z < fence.FloorM)
+}
+
+// outwardXY returns a unit vector pointing out of the horizontal fence boundary nearest
+// to a point which is outside it.
+func (fence Geofence) outwardXY(x, y float32) (ox, oy float32) {
+	if fence.MaxRadiusM > 0 && x*x+y*y > fence.MaxRadiusM*fence.MaxRadiusM {
+		ox, oy = x, y
+	}
+	if len(fence.Polygon) > 0 && !pointInPolygon(Point2D{x, y}, fence.Polygon) {
+		n := nearestOnPolygon(Point2D{x, y}, fence.Polygon)
+		ox += x - n.X
+		oy += y - n.Y
+	}
+	if l := float32(math.Hypot(float64(ox), float64(oy))); l > 0 {
+		return ox / l, oy / l
+	}
+	return 0, 0
+}
+
+// nearestOnPolygon returns the point on the polygon's boundary which is nearest to p.
+func nearestOnPolygon(p Point2D, poly []Point2D) (nearest Point2D) {
+	best := float32(math.MaxFloat32)
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[j], poly[i]
+		abX, abY := b.X-a.X, b.Y-a.Y
+		var t float32
+		if l2 := abX*abX + abY*abY; l2 > 0 {
+			t = ((p.X-a.X)*abX + (p.Y-a.Y)*abY) / l2
+		}
+		switch {
+		case t < 0:
+			t = 0
+		case t > 1:
+			t = 1
+		}
+		c := Point2D{a.X + abX*t, a.Y + abY*t}
+		if d := (p.X-c.X)*(p.X-c.X) + (p.Y-c.Y)*(p.Y-c.Y); d < best {
+			best = d
+			nearest = c
+		}
+	}
+	return nearest
+}
+
+// fenceAllowsXY tests whether an Auto... target in the home frame is within any geofence.
+func (tello *Tello) fenceAllowsXY(x, y float32) bool {
+	fence, set := tello.effectiveFence()
+	return !set || fence.ContainsXY(x, y)
+}
+
+// fenceAllowsHeight tests whether an Auto... target height in metres is within any geofence.
+func (tello *Tello) fenceAllowsHeight(z float32) bool {
+	fence, set := tello.effectiveFence()
+	return !set || fence.ContainsHeight(z)
+}
+
+// checkGeofence is called by keepAlive to test the latest position against any geofence,
+// reporting and acting on any change of state.
+func (tello *Tello) checkGeofence() {
+	fence, set := tello.effectiveFence()
+	if !set {
+		return
+	}
+	homeSet := tello.IsHomeSet()
+	x, y, z, yaw := tello.homeRelativePos()
+	tello.fdMu.RLock()
+	flying := tello.fd.Flying
+	tello.fdMu.RUnlock()
+
+	var fs fenceState
+	fs.yaw = yaw
+	if homeSet && !fence.ContainsXY(x, y) {
+		fs.outX, fs.outY = fence.outwardXY(x, y)
+	}
+	fs.aboveCeiling = fence.CeilingM > 0 && z > fence.CeilingM
+	fs.belowFloor = flying && fence.FloorM > 0 && z < fence.FloorM
+	fs.outside = fs.outX != 0 || fs.outY != 0 || fs.aboveCeiling || fs.belowFloor
+	fs.pushBack = fence.Action == FencePushBack
+
+	tello.fenceMu.Lock()
+	wasOutside := tello.fenceState.outside
+	tello.fenceState = fs
+	tello.fenceMu.Unlock()
+
+	switch {
+	case fs.outside && !wasOutside:
+		tello.publishEvent(EvGeofenceBreach, "Drone at (%.2f, %.2f, %.1f) is outside the geofence", x, y, z)
+		go tello.fenceBreachAction(fence, fs)
+	case !fs.outside && wasOutside:
+		tello.publishEvent(EvGeofenceClear, "Drone at (%.2f, %.2f, %.1f) is back inside the geofence", x, y, z)
+	}
+}
+
+func (tello *Tello) fenceBreachAction(fence Geofence, fs fenceState) {
+	if fence.Action == FenceBlock {
+		return
+	}
+	tello.cancelAllAutopilots()
+	tello.Hover()
+	switch fence.Action {
+	case FenceReturnHome:
+		if err := tello.fenceReturnHome(fence, fs); err != nil {
+			tello.publishEvent(EvGeofenceBreach, "Cannot return home after geofence breach (%v), landing", err)
+			tello.Land()
+		}
+	case FenceLand:
+		tello.Land()
+	}
+}
+
+// fenceReturnHome first brings the drone back within any breached ceiling or floor, so that
+// it does not fly home outside the fence, then starts AutoReturnHome().
+func (tello *Tello) fenceReturnHome(fence Geofence, fs fenceState) error {
+	if !fence.ContainsXY(0, 0) {
+		return errors.New("home point is outside the geofence")
+	}
+	if fs.aboveCeiling || fs.belowFloor {
+		targetM := fence.CeilingM - fenceRecoveryMarginM
+		if fs.belowFloor {
+			targetM = fence.FloorM + fenceRecoveryMarginM
+		} else if targetM <= fence.FloorM || targetM < 0 {
+			targetM = (fence.CeilingM + fence.FloorM) / 2
+		}
+		hDone, err := tello.AutoFlyToHeight(int16(math.Round(float64(targetM * 10))))
+		if err != nil {
+			return err
+		}
+		<-hDone
+		if _, _, z, _ := tello.homeRelativePos(); !fence.ContainsHeight(z) {
+			return errors.New("could not regain the permitted height")
+		}
+	}
+	_, err := tello.AutoReturnHome(false)
+	return err
+}
+
+// fenceSticks modifies stick values according to the latest geofence check: any component
+// which would take the drone further outside is removed, and if the action is FencePushBack
+// the drone is flown back inside.
+// The caller must hold ctrlMu.
+func (tello *Tello) fenceSticks(rx, ry, lx, ly int16) (int16, int16, int16, int16) {
+	tello.fenceMu.RLock()
+	fs := tello.fenceState
+	tello.fenceMu.RUnlock()
+	if !fs.outside {
+		return rx, ry, lx, ly
+	}
+	if fs.outX != 0 || fs.outY != 0 {
+		// body-frame outward direction
+		oBodyX, oBodyY := calcXYdeltas(fs.yaw, 0, 0, fs.outX, fs.outY)
+		if fs.pushBack {
+			rx = int16(-oBodyX * autoPilotSpeedSlow)
+			ry = int16(-oBodyY * autoPilotSpeedSlow)
+		} else if dot := float32(rx)*oBodyX + float32(ry)*oBodyY; dot > 0 {
+			rx = int16(float32(rx) - dot*oBodyX)
+			ry = int16(float32(ry) - dot*oBodyY)
+		}
+	}
+	switch {
+	case fs.aboveCeiling && fs.pushBack:
+		ly = -autoPilotSpeedSlow
+	case fs.aboveCeiling && ly > 0:
+		ly = 0
+	case fs.belowFloor && fs.pushBack:
+		ly = autoPilotSpeedSlow
+	case fs.belowFloor && ly < 0:
+		ly = 0
+	}
+	return rx, ry, lx, ly
+}
@@ -0,0 +1,119 @@
+// geofence_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"math"
+	"testing"
+)
+
+func TestGeofenceContains(t *testing.T) {
+	fence := Geofence{Polygon: BoxPolygon(-2, -2, 2, 4), MaxRadiusM: 3, CeilingM: 2.5, FloorM: 0.5}
+	if !fence.ContainsXY(0, 0) || !fence.ContainsXY(1.5, 1.5) {
+		t.Error("Expected points to be inside fence")
+	}
+	if fence.ContainsXY(0, 3.5) { // inside box, outside radius
+		t.Error("Expected point beyond radius to be outside fence")
+	}
+	if fence.ContainsXY(-2.5, 0) { // inside radius, outside box
+		t.Error("Expected point beyond polygon to be outside fence")
+	}
+	if !fence.ContainsHeight(1) || fence.ContainsHeight(3) || fence.ContainsHeight(0.2) {
+		t.Error("Unexpected height containment")
+	}
+
+	ox, oy := fence.outwardXY(-2.5, 0)
+	if math.Abs(float64(ox+1)) > 0.0001 || math.Abs(float64(oy)) > 0.0001 {
+		t.Errorf("Expected outward direction (-1, 0), got (%f, %f)", ox, oy)
+	}
+}
+
+func TestSetGeofence(t *testing.T) {
+	drone := new(Tello)
+	if err := drone.SetGeofence(Geofence{Polygon: []Point2D{{0, 0}, {1, 1}}}); err == nil {
+		t.Error("Expected error for 2-vertex polygon")
+	}
+	if err := drone.SetGeofence(Geofence{CeilingM: 1, FloorM: 2}); err == nil {
+		t.Error("Expected error for floor above ceiling")
+	}
+	if err := drone.SetGeofence(Geofence{MaxRadiusM: 5, CeilingM: 3}); err != nil {
+		t.Errorf("SetGeofence failed with error %v", err)
+	}
+	if !drone.fenceAllowsHeight(2) || drone.fenceAllowsHeight(4) || drone.fenceAllowsXY(4, 4) {
+		t.Error("Unexpected target checks")
+	}
+	drone.ClearGeofence()
+	if _, set := drone.GetGeofence(); set || !drone.fenceAllowsXY(40, 40) {
+		t.Error("Expected fence to be cleared")
+	}
+}
+
+func TestCheckGeofence(t *testing.T) {
+	drone := new(Tello)
+	events, stop := drone.ListenEvents()
+	defer stop()
+	drone.SetGeofence(Geofence{MaxRadiusM: 2, CeilingM: 2})
+	drone.fd.Height = 10
+	drone.SetHome()
+
+	drone.checkGeofence()
+	if drone.IsOutsideGeofence() {
+		t.Error("Expected to be inside fence")
+	}
+
+	// fly out along +X, facing +Y (yaw 0) and above the ceiling
+	drone.fd.MVO.PositionX = 3
+	drone.fd.Height = 25
+	drone.checkGeofence()
+	if !drone.IsOutsideGeofence() {
+		t.Fatal("Expected to be outside fence")
+	}
+	if ev := <-events; ev.Type != EvGeofenceBreach {
+		t.Errorf("Expected breach event, got %v", ev)
+	}
+	// moving right and up should be blocked, moving forward and turning should not
+	rx, ry, lx, ly := drone.fenceSticks(16384, 16384, 1000, 16384)
+	if rx != 0 || ry != 16384 || lx != 1000 || ly != 0 {
+		t.Errorf("Unexpected sticks after fencing: %d, %d, %d, %d", rx, ry, lx, ly)
+	}
+	// moving left and down should be allowed
+	rx, _, _, ly = drone.fenceSticks(-16384, 0, 0, -16384)
+	if rx != -16384 || ly != -16384 {
+		t.Errorf("Unexpected sticks after fencing: %d, %d", rx, ly)
+	}
+
+	drone.fd.MVO.PositionX = 1
+	drone.fd.Height = 15
+	drone.checkGeofence()
+	if ev := <-events; ev.Type != EvGeofenceClear {
+		t.Errorf("Expected clear event, got %v", ev)
+	}
+}
+
+func TestFenceReturnHomeOutside(t *testing.T) {
+	drone := new(Tello)
+	fence := Geofence{Polygon: BoxPolygon(1, 1, 5, 5), Action: FenceReturnHome}
+	drone.SetGeofence(fence)
+	if err := drone.fenceReturnHome(fence, fenceState{outside: true, outX: 1}); err == nil {
+		t.Error("Expected return home to be refused when home is outside the fence")
+	}
+}
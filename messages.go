@@ -75,7 +75,7 @@ const (
 	msgDoTakePic           = 0x0030 // 48
 	msgSwitchPicVideo      = 0x0031 // 49
 	msgDoStartRec          = 0x0032 // 50
-	msgExposureVals        = 0x0034 // 52 (Get or set?)
+	msgExposureVals        = 0x0034 // 52 (both get and set)
 	msgLightStrength       = 0x0035 // 53
 	msgQueryJPEGQuality    = 0x0037 // 55
 	msgError1              = 0x0043 // 67
@@ -147,6 +147,22 @@ const (
 	Vbr4M              // Set the VBR to 4mbps
 )
 
+// WifiRegion is a regulatory region, expressed as an ISO 3166 two-letter country code.
+type WifiRegion string
+
+// Some common Wifi regions, any valid two-letter code may be used...
+const (
+	RegionAustralia     WifiRegion = "AU"
+	RegionCanada        WifiRegion = "CA"
+	RegionChina         WifiRegion = "CN"
+	RegionFrance        WifiRegion = "FR"
+	RegionGermany       WifiRegion = "DE"
+	RegionJapan         WifiRegion = "JP"
+	RegionKorea         WifiRegion = "KR"
+	RegionUnitedKingdom WifiRegion = "GB"
+	RegionUnitedStates  WifiRegion = "US"
+)
+
 const (
 	vmNormal = 0
 	vmWide   = 1
@@ -192,6 +208,7 @@ type fileChunk struct {
 // This data is not all sent at once from the drone, different fields may be updated
 // at varying rates.
 type FlightData struct {
+	AttitudeLimit            float32 // maximum tilt in degrees
 	BatteryCritical          bool
 	BatteryLow               bool
 	BatteryMilliVolts        int16
@@ -202,9 +219,11 @@ type FlightData struct {
 	DroneFlyTimeLeft         int16
 	DroneHover               bool
 	EastSpeed                int16
+	EIS                      bool // electronic image stabilisation, see SetEIS()
 	ElectricalMachineryState uint8
 	EmOpen                   bool
 	ErrorState               bool
+	Exposure                 ExposureLevel // see SetExposure()
 	FactoryMode              bool
 	Flying                   bool
 	FlyMode                  uint8
@@ -216,6 +235,7 @@ type FlightData struct {
 	GroundSpeed              int16
 	Height                   int16 // seems to be in decimetres
 	IMU                      IMUData
+	JPEGQuality              uint8
 	ImuCalibrationState      int8
 	ImuState                 bool
 	LightStrength            uint8
@@ -235,6 +255,7 @@ type FlightData struct {
 	VerticalSpeed            int16
 	VideoBitrate             VBR
 	WifiInterference         uint8
+	WifiRegion               WifiRegion
 	WifiStrength             uint8
 	WindState                bool
 }
@@ -0,0 +1,387 @@
+// navmap.go
+
+// This file contains a simple indoor map model and a path planner which avoids obstacles.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"container/heap"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math"
+)
+
+// navMapMaxCells limits the size of a NavMap to keep planning fast.
+const navMapMaxCells = 1000000
+
+const (
+	// AutoNavSpeedMS is the maximum speed in m/s at which AutoNavigateTo() flies.
+	AutoNavSpeedMS = 0.5
+	// AutoNavAccelMS2 is the maximum acceleration in m/s/s with which AutoNavigateTo() flies.
+	AutoNavAccelMS2 = 0.5
+)
+
+// Point2D is a horizontal position in the home frame, in metres.
+type Point2D struct {
+	X, Y float32
+}
+
+// Obstacle is a polygonal obstacle in the home frame which rises from the ground to TopM metres.
+// A TopM of zero means the obstacle reaches the ceiling, so can never be overflown.
+type Obstacle struct {
+	Polygon []Point2D
+	TopM    float32
+}
+
+// NavMap is a 2.5D occupancy grid in the home frame.  Each cell records the height of
+// the tallest obstacle within it, and anywhere outside the grid is treated as blocked.
+// Obstacles are rasterised by testing the centre of each cell, so the cell size should be
+// small compared with the safety margins used for planning.
+type NavMap struct {
+	OriginX, OriginY float32 // home frame position of the outer corner of cell (0, 0)
+	CellM            float32 // cell size in metres
+	Cols, Rows       int     // number of cells along X and Y
+	Obstacles        []Obstacle
+	tops             []float32 // obstacle height in each cell; 0 is free, +Inf reaches the ceiling
+}
+
+// NewNavMap creates an empty map of cols x rows square cells of size cellM metres, whose
+// corner with the lowest X and Y coordinates is at (originX, originY) in the home frame.
+func NewNavMap(originX, originY, cellM float32, cols, rows int) (*NavMap, error) {
+	m := &NavMap{OriginX: originX, OriginY: originY, CellM: cellM, Cols: cols, Rows: rows}
+	if err := m.init(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *NavMap) init() error {
+	if m.CellM <= 0 || m.Cols <= 0 || m.Rows <= 0 {
+		return errors.New("Map cell size and dimensions must be positive")
+	}
+	if m.Cols*m.Rows > navMapMaxCells {
+		return errors.New("Map has too many cells")
+	}
+	m.tops = make([]float32, m.Cols*m.Rows)
+	obs := m.Obstacles
+	m.Obstacles = nil
+	for _, ob := range obs {
+		if err := m.AddObstacle(ob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadNavMap reads a map from a JSON file, eg.
+//
+//	{"OriginX": -5, "OriginY": -5, "CellM": 0.1, "Cols": 200, "Rows": 100,
+//	 "Obstacles": [{"Polygon": [{"X": 1, "Y": 1}, {"X": 2, "Y": 1}, {"X": 2, "Y": 4}], "TopM": 2.5}]}
+func LoadNavMap(filename string) (*NavMap, error) {
+	buf, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m := &NavMap{}
+	if err = json.Unmarshal(buf, m); err != nil {
+		return nil, err
+	}
+	if err = m.init(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Save writes the map to a JSON file which can be read by LoadNavMap().
+func (m *NavMap) Save(filename string) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf, 0644)
+}
+
+// AddObstacle marks every cell whose centre lies within the obstacle's polygon.
+func (m *NavMap) AddObstacle(ob Obstacle) error {
+	if len(ob.Polygon) < 3 {
+		return errors.New("An obstacle polygon must have at least 3 vertices")
+	}
+	if ob.TopM < 0 {
+		return errors.New("Obstacle height must not be negative")
+	}
+	top := ob.TopM
+	if top == 0 {
+		top = float32(math.Inf(1))
+	}
+	for r := 0; r < m.Rows; r++ {
+		for c := 0; c < m.Cols; c++ {
+			if pointInPolygon(m.cellCentre(c, r), ob.Polygon) && top > m.tops[r*m.Cols+c] {
+				m.tops[r*m.Cols+c] = top
+			}
+		}
+	}
+	m.Obstacles = append(m.Obstacles, ob)
+	return nil
+}
+
+// AddBox is a convenience func which adds a rectangular obstacle aligned with the home frame axes.
+func (m *NavMap) AddBox(minX, minY, maxX, maxY, topM float32) error {
+	return m.AddObstacle(Obstacle{Polygon: BoxPolygon(minX, minY, maxX, maxY), TopM: topM})
+}
+
+// Blocked tests whether the given point is outside the map or within marginM metres
+// (horizontally or vertically) of an obstacle.
+func (m *NavMap) Blocked(p Point3D, marginM float32) bool {
+	c, r, ok := m.cellOf(p.X, p.Y)
+	if !ok {
+		return true
+	}
+	return m.occupancy(p.Z, marginM)[r*m.Cols+c]
+}
+
+// PlanPath finds a path from one point to another which keeps at least marginM metres from
+// every obstacle.  The path climbs or descends to the higher of the two heights at the start,
+// travels at that height, then changes to the goal height at the end.
+// Waypoints are only placed where the path changes direction.
+func (m *NavMap) PlanPath(from, to Point3D, marginM float32) ([]Point3D, error) {
+	if marginM < 0 {
+		return nil, errors.New("Safety margin must not be negative")
+	}
+	alt := from.Z
+	if to.Z > alt {
+		alt = to.Z
+	}
+	occ := m.occupancy(alt, marginM)
+	sc, sr, ok := m.cellOf(from.X, from.Y)
+	if !ok || occ[sr*m.Cols+sc] {
+		return nil, errors.New("Start point is blocked or outside the map")
+	}
+	gc, gr, ok := m.cellOf(to.X, to.Y)
+	if !ok || occ[gr*m.Cols+gc] || m.occupancy(to.Z, marginM)[gr*m.Cols+gc] {
+		return nil, errors.New("Goal point is blocked or outside the map")
+	}
+
+	cells, err := m.aStar(occ, sr*m.Cols+sc, gr*m.Cols+gc)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep only the cells needed to maintain line-of-sight
+	path := []Point3D{from}
+	if from.Z != alt {
+		path = append(path, Point3D{X: from.X, Y: from.Y, Z: alt})
+	}
+	last := path[len(path)-1]
+	for i := 1; i < len(cells); i++ {
+		var next Point3D
+		if i == len(cells)-1 {
+			next = Point3D{X: to.X, Y: to.Y, Z: alt}
+		} else {
+			p := m.cellCentre(cells[i+1]%m.Cols, cells[i+1]/m.Cols)
+			if m.lineOfSight(occ, last, Point3D{X: p.X, Y: p.Y}) {
+				continue
+			}
+			c := m.cellCentre(cells[i]%m.Cols, cells[i]/m.Cols)
+			next = Point3D{X: c.X, Y: c.Y, Z: alt}
+		}
+		path = append(path, next)
+		last = next
+	}
+	if len(cells) == 1 {
+		path = append(path, Point3D{X: to.X, Y: to.Y, Z: alt})
+	}
+	if to.Z != alt {
+		path = append(path, to)
+	}
+	return path, nil
+}
+
+// occupancy returns the cells which are unusable at the given altitude, with obstacles grown by the margin.
+func (m *NavMap) occupancy(alt, marginM float32) []bool {
+	occ := make([]bool, len(m.tops))
+	reach := int(math.Ceil(float64(marginM / m.CellM)))
+	for r := 0; r < m.Rows; r++ {
+		for c := 0; c < m.Cols; c++ {
+			top := m.tops[r*m.Cols+c]
+			if top == 0 || top+marginM <= alt {
+				continue
+			}
+			for dr := -reach; dr <= reach; dr++ {
+				for dc := -reach; dc <= reach; dc++ {
+					rr, cc := r+dr, c+dc
+					if rr < 0 || rr >= m.Rows || cc < 0 || cc >= m.Cols {
+						continue
+					}
+					if float32(math.Hypot(float64(dr), float64(dc)))*m.CellM <= marginM {
+						occ[rr*m.Cols+cc] = true
+					}
+				}
+			}
+		}
+	}
+	return occ
+}
+
+// aStar finds the shortest 8-connected route between two cells, not cutting blocked corners.
+func (m *NavMap) aStar(occ []bool, start, goal int) ([]int, error) {
+	gc, gr := goal%m.Cols, goal/m.Cols
+	heuristic := func(cell int) float64 { // octile distance
+		dx := math.Abs(float64(cell%m.Cols - gc))
+		dy := math.Abs(float64(cell/m.Cols - gr))
+		return math.Max(dx, dy) + (math.Sqrt2-1)*math.Min(dx, dy)
+	}
+	cost := make([]float64, len(occ))
+	for i := range cost {
+		cost[i] = math.Inf(1)
+	}
+	from := make([]int, len(occ))
+	closed := make([]bool, len(occ))
+	cost[start] = 0
+	open := &cellQueue{{cell: start, priority: heuristic(start)}}
+
+	for open.Len() > 0 {
+		cur := heap.Pop(open).(cellPriority).cell
+		if cur == goal {
+			route := []int{goal}
+			for route[0] != start {
+				route = append([]int{from[route[0]]}, route...)
+			}
+			return route, nil
+		}
+		if closed[cur] {
+			continue
+		}
+		closed[cur] = true
+		cc, cr := cur%m.Cols, cur/m.Cols
+		for dr := -1; dr <= 1; dr++ {
+			for dc := -1; dc <= 1; dc++ {
+				nc, nr := cc+dc, cr+dr
+				if (dc == 0 && dr == 0) || nc < 0 || nc >= m.Cols || nr < 0 || nr >= m.Rows {
+					continue
+				}
+				next := nr*m.Cols + nc
+				if occ[next] || closed[next] {
+					continue
+				}
+				step := 1.0
+				if dc != 0 && dr != 0 {
+					if occ[cr*m.Cols+nc] || occ[nr*m.Cols+cc] {
+						continue // don't cut corners
+					}
+					step = math.Sqrt2
+				}
+				if c := cost[cur] + step; c < cost[next] {
+					cost[next] = c
+					from[next] = cur
+					heap.Push(open, cellPriority{cell: next, priority: c + heuristic(next)})
+				}
+			}
+		}
+	}
+	return nil, errors.New("No path found to goal")
+}
+
+// lineOfSight tests whether the straight line between two points crosses no blocked cells.
+func (m *NavMap) lineOfSight(occ []bool, a, b Point3D) bool {
+	dist := math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y))
+	steps := int(math.Ceil(dist/float64(m.CellM)*2)) + 1
+	for s := 0; s <= steps; s++ {
+		f := float32(s) / float32(steps)
+		c, r, ok := m.cellOf(a.X+(b.X-a.X)*f, a.Y+(b.Y-a.Y)*f)
+		if !ok || occ[r*m.Cols+c] {
+			return false
+		}
+	}
+	return true
+}
+
+func (m *NavMap) cellOf(x, y float32) (c, r int, ok bool) {
+	c = int(math.Floor(float64((x - m.OriginX) / m.CellM)))
+	r = int(math.Floor(float64((y - m.OriginY) / m.CellM)))
+	return c, r, c >= 0 && c < m.Cols && r >= 0 && r < m.Rows
+}
+
+func (m *NavMap) cellCentre(c, r int) Point2D {
+	return Point2D{X: m.OriginX + (float32(c)+0.5)*m.CellM, Y: m.OriginY + (float32(r)+0.5)*m.CellM}
+}
+
+// pointInPolygon uses the even-odd rule.
+func pointInPolygon(p Point2D, poly []Point2D) bool {
+	in := false
+	for i, j := 0, len(poly)-1; i < len(poly); j, i = i, i+1 {
+		a, b := poly[i], poly[j]
+		if (a.Y > p.Y) != (b.Y > p.Y) && p.X < (b.X-a.X)*(p.Y-a.Y)/(b.Y-a.Y)+a.X {
+			in = !in
+		}
+	}
+	return in
+}
+
+// cellQueue is a priority queue of cells for A*.
+type cellPriority struct {
+	cell     int
+	priority float64
+}
+
+type cellQueue []cellPriority
+
+func (q cellQueue) Len() int            { return len(q) }
+func (q cellQueue) Less(i, j int) bool  { return q[i].priority < q[j].priority }
+func (q cellQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *cellQueue) Push(x interface{}) { *q = append(*q, x.(cellPriority)) }
+func (q *cellQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
+
+// AutoNavigateTo plans a path from the drone's current position to the goal (in the home
+// frame) which keeps at least marginM metres from the obstacles in the map, then flies
+// it via AutoFlyTrajectory().  The planned legs are only clear of obstacles along the straight
+// lines between their ends, so they are flown as a NewStraightTrajectory().
+// The home point must have been previously set.
+// The func returns as soon as the path is planned; cancel the flight via CancelAutoFlyTrajectory().
+// The caller may optionally listen on the 'done' channel for a signal that
+// the navigation is complete (or has been cancelled).
+func (tello *Tello) AutoNavigateTo(m *NavMap, goal Point3D, marginM float32) (done chan bool, err error) {
+	if m == nil {
+		return nil, errors.New("No map supplied")
+	}
+	if tello.IsAutoTuning() {
+		return nil, errAutoTuning
+	}
+	if !tello.IsHomeSet() {
+		return nil, errors.New("Cannot AutoFly as home point has not be set (or is invalid)")
+	}
+	x, y, z, _ := tello.homeRelativePos()
+	path, err := m.PlanPath(Point3D{X: x, Y: y, Z: z}, goal, marginM)
+	if err != nil {
+		return nil, err
+	}
+	traj, err := NewStraightTrajectory(path, AutoNavSpeedMS, AutoNavAccelMS2)
+	if err != nil {
+		return nil, err
+	}
+	return tello.AutoFlyTrajectory(traj)
+}
@@ -0,0 +1,170 @@
+// navmap_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"io/ioutil"
+	"log"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestMap returns a 10m x 10m map centred on home with a ceiling-high wall across
+// it at Y=2 from X=-5 to X=3, and a 1m high crate at (-3, -3).
+func newTestMap(t *testing.T) *NavMap {
+	m, err := NewNavMap(-5, -5, 0.1, 100, 100)
+	if err != nil {
+		t.Fatalf("NewNavMap failed with error %v", err)
+	}
+	if err = m.AddBox(-5, 2, 3, 2.2, 0); err != nil {
+		t.Fatalf("AddBox failed with error %v", err)
+	}
+	if err = m.AddBox(-3.5, -3.5, -2.5, -2.5, 1); err != nil {
+		t.Fatalf("AddBox failed with error %v", err)
+	}
+	return m
+}
+
+func TestNavMapBlocked(t *testing.T) {
+	m := newTestMap(t)
+	if !m.Blocked(Point3D{X: 0, Y: 2.1, Z: 1}, 0) {
+		t.Error("Expected wall to be blocked")
+	}
+	if !m.Blocked(Point3D{X: 0, Y: 1.8, Z: 1}, 0.3) {
+		t.Error("Expected margin around wall to be blocked")
+	}
+	if m.Blocked(Point3D{X: 0, Y: 1.5, Z: 1}, 0.3) {
+		t.Error("Expected point beyond margin to be free")
+	}
+	if !m.Blocked(Point3D{X: -3, Y: -3, Z: 0.5}, 0.2) || m.Blocked(Point3D{X: -3, Y: -3, Z: 1.5}, 0.2) {
+		t.Error("Expected crate to be blocked below its top only")
+	}
+	if !m.Blocked(Point3D{X: 6, Y: 0, Z: 1}, 0) {
+		t.Error("Expected outside the map to be blocked")
+	}
+}
+
+func TestPlanPath(t *testing.T) {
+	m := newTestMap(t)
+	from, to := Point3D{X: 0, Y: 0, Z: 1}, Point3D{X: 0, Y: 4, Z: 1.5}
+	path, err := m.PlanPath(from, to, 0.3)
+	if err != nil {
+		t.Fatalf("PlanPath failed with error %v", err)
+	}
+	log.Printf("Path: %v\n", path)
+	if path[0] != from || path[len(path)-1] != to {
+		t.Errorf("Expected path from %v to %v, got %v", from, to, path)
+	}
+	// every leg must be clear of obstacles
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		for s := 0; s <= 100; s++ {
+			f := float32(s) / 100
+			p := Point3D{X: a.X + (b.X-a.X)*f, Y: a.Y + (b.Y-a.Y)*f, Z: a.Z + (b.Z-a.Z)*f}
+			if m.Blocked(p, 0.25) {
+				t.Fatalf("Leg %v -> %v passes through an obstacle at %v", a, b, p)
+			}
+		}
+	}
+	// the route must go round the east end of the wall
+	var maxX float32
+	for _, p := range path {
+		if p.X > maxX {
+			maxX = p.X
+		}
+	}
+	if maxX < 3.3 {
+		t.Errorf("Expected path to pass east of X=3.3, got max X of %f", maxX)
+	}
+
+	// the crate can be overflown but not flown past at low level
+	path, err = m.PlanPath(Point3D{X: -4.5, Y: -3, Z: 1.5}, Point3D{X: -1.5, Y: -3, Z: 1.5}, 0.2)
+	if err != nil || len(path) != 2 {
+		t.Errorf("Expected direct path over crate, got %v, %v", path, err)
+	}
+
+	if _, err = m.PlanPath(from, Point3D{X: 0, Y: 2.1, Z: 1}, 0.3); err == nil {
+		t.Error("Expected error for goal inside wall")
+	}
+}
+
+func TestLoadNavMap(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "map.json")
+	json := `{"OriginX": -5, "OriginY": -5, "CellM": 0.1, "Cols": 100, "Rows": 100,
+	"Obstacles": [{"Polygon": [{"X": -5, "Y": 2}, {"X": 3, "Y": 2}, {"X": 3, "Y": 2.2}, {"X": -5, "Y": 2.2}]}]}`
+	if err := ioutil.WriteFile(filename, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m, err := LoadNavMap(filename)
+	if err != nil {
+		t.Fatalf("LoadNavMap failed with error %v", err)
+	}
+	if !m.Blocked(Point3D{X: 0, Y: 2.1, Z: 10}, 0) {
+		t.Error("Expected loaded wall to be blocked")
+	}
+	if err = m.Save(filename); err != nil {
+		t.Fatalf("Save failed with error %v", err)
+	}
+	if m2, err := LoadNavMap(filename); err != nil || len(m2.Obstacles) != 1 {
+		t.Errorf("Expected map to survive save and reload, got %v", err)
+	}
+}
+
+func TestNavigationTrajectoryKeepsToLegs(t *testing.T) {
+	m := newTestMap(t)
+	const marginM = 0.3
+	path, err := m.PlanPath(Point3D{X: 0, Y: 0, Z: 1}, Point3D{X: 0, Y: 4, Z: 1.5}, marginM)
+	if err != nil {
+		t.Fatalf("PlanPath failed with error %v", err)
+	}
+	traj, err := NewStraightTrajectory(path, AutoNavSpeedMS, AutoNavAccelMS2)
+	if err != nil {
+		t.Fatalf("NewStraightTrajectory failed with error %v", err)
+	}
+	for el := time.Duration(0); el <= traj.Duration(); el += 20 * time.Millisecond {
+		p, _ := traj.Sample(el)
+		best := math.MaxFloat64
+		for i := 1; i < len(path); i++ {
+			if d := distToSegment(p, path[i-1], path[i]); d < best {
+				best = d
+			}
+		}
+		if best > 0.01 {
+			t.Fatalf("Trajectory at %v strays %.3fm from the planned legs", p, best)
+		}
+		if m.Blocked(p, marginM-0.05) {
+			t.Fatalf("Trajectory at %v is within the obstacle margin", p)
+		}
+	}
+}
+
+func distToSegment(p, a, b Point3D) float64 {
+	ab, ap := pointDelta(a, b), pointDelta(a, p)
+	l2 := ab[0]*ab[0] + ab[1]*ab[1] + ab[2]*ab[2]
+	t := 0.0
+	if l2 > 0 {
+		t = math.Max(0, math.Min(1, (ap[0]*ab[0]+ap[1]*ab[1]+ap[2]*ab[2])/l2))
+	}
+	return vecLen(pointDelta(lerpPoint(a, b, t), p))
+}
@@ -0,0 +1,200 @@
+// phase.go
+
+// This file contains the flight phase state machine.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// FlightPhase is our interpretation of what the drone is currently doing.
+type FlightPhase int
+
+// Flight phases...
+const (
+	PhaseUnknown     FlightPhase = iota // no flight status received yet
+	PhaseGrounded                       // on the ground, motors off
+	PhaseTakingOff                      // takeoff requested or climbing to the initial hover
+	PhaseHovering                       // flying and holding position
+	PhaseFlying                         // flying and moving
+	PhaseLanding                        // Land() requested and still airborne
+	PhaseLanded                         // back on the ground (or in the hand) after a flight, motors off
+	PhaseThrowReady                     // ThrowTakeOff() requested, waiting to be thrown
+	PhasePalmLanding                    // PalmLand() requested and still airborne
+)
+
+var flightPhaseNames = map[FlightPhase]string{
+	PhaseUnknown:     "Unknown",
+	PhaseGrounded:    "Grounded",
+	PhaseTakingOff:   "Taking Off",
+	PhaseHovering:    "Hovering",
+	PhaseFlying:      "Flying",
+	PhaseLanding:     "Landing",
+	PhaseLanded:      "Landed",
+	PhaseThrowReady:  "Throw Ready",
+	PhasePalmLanding: "Palm Landing",
+}
+
+func (fp FlightPhase) String() string {
+	if name, ok := flightPhaseNames[fp]; ok {
+		return name
+	}
+	return fmt.Sprintf("Phase %d", int(fp))
+}
+
+const (
+	takeoffPendingTimeout = 5 * time.Second  // how long after TakeOff() we expect the drone to be flying
+	throwPendingTimeout   = 10 * time.Second // how long the drone waits to be thrown after ThrowTakeOff()
+	// FlyMode values as observed from the drone
+	flyModeTakeoff = 11
+	flyModeLanding = 12
+)
+
+// phaseInputs holds everything the state machine depends upon.
+type phaseInputs struct {
+	flying, hover, onGround      bool
+	flyMode                      uint8
+	vSpeed                       int16
+	landing, palmLanding         bool
+	takeoffPending, throwPending bool
+}
+
+// nextPhase is the flight phase state machine.
+// After a flight the drone is only considered Landed once it reports being on the ground,
+// until then it remains Landing; a palm landing ends in the hand so OnGround is not required.
+func nextPhase(prev FlightPhase, in phaseInputs) FlightPhase {
+	if !in.flying {
+		switch {
+		case in.throwPending:
+			return PhaseThrowReady
+		case in.takeoffPending:
+			return PhaseTakingOff
+		case prev == PhaseLanded || prev == PhasePalmLanding:
+			return PhaseLanded
+		case prev == PhaseHovering || prev == PhaseFlying || prev == PhaseLanding:
+			if in.onGround {
+				return PhaseLanded
+			}
+			return PhaseLanding
+		}
+		return PhaseGrounded
+	}
+	climbing := in.vSpeed > 0
+	switch {
+	case in.palmLanding:
+		return PhasePalmLanding
+	case in.landing || in.flyMode == flyModeLanding:
+		return PhaseLanding
+	case in.flyMode == flyModeTakeoff:
+		return PhaseTakingOff
+	case prev == PhaseGrounded || prev == PhaseLanded || prev == PhaseThrowReady || prev == PhaseTakingOff:
+		if !in.hover || climbing {
+			return PhaseTakingOff
+		}
+	}
+	if in.hover && !climbing {
+		return PhaseHovering
+	}
+	return PhaseFlying
+}
+
+// updatePhase is called whenever fresh flight status is received.
+func (tello *Tello) updatePhase() {
+	var in phaseInputs
+	tello.fdMu.RLock()
+	in.flying = tello.fd.Flying
+	in.hover = tello.fd.DroneHover
+	in.onGround = tello.fd.OnGround
+	in.flyMode = tello.fd.FlyMode
+	in.vSpeed = tello.fd.VerticalSpeed
+	tello.fdMu.RUnlock()
+
+	tello.ctrlMu.Lock()
+	if in.flying { // takeoff has happened
+		tello.ctrlTakeoffAt = time.Time{}
+		tello.ctrlThrowAt = time.Time{}
+	}
+	in.landing = tello.ctrlLanding
+	in.palmLanding = tello.ctrlPalmLanding
+	in.takeoffPending = !tello.ctrlTakeoffAt.IsZero() && time.Since(tello.ctrlTakeoffAt) < takeoffPendingTimeout
+	in.throwPending = !tello.ctrlThrowAt.IsZero() && time.Since(tello.ctrlThrowAt) < throwPendingTimeout
+	if !in.flying { // any landing has finished
+		tello.ctrlLanding = false
+		tello.ctrlPalmLanding = false
+	}
+	tello.ctrlMu.Unlock()
+
+	tello.phaseMu.Lock()
+	prev := tello.phase
+	phase := nextPhase(prev, in)
+	if phase != prev {
+		tello.phase = phase
+		if tello.phaseChanged != nil {
+			close(tello.phaseChanged)
+		}
+		tello.phaseChanged = make(chan struct{})
+	}
+	tello.phaseMu.Unlock()
+
+	if phase != prev {
+		tello.publishEvent(EvPhaseChange, "%s -> %s", prev, phase)
+	}
+}
+
+// GetFlightPhase returns the current flight phase.
+func (tello *Tello) GetFlightPhase() (phase FlightPhase) {
+	tello.phaseMu.RLock()
+	phase = tello.phase
+	tello.phaseMu.RUnlock()
+	return phase
+}
+
+// WaitForPhase blocks until the drone is in one of the given flight phases, or the context ends.
+// Eg. to wait up to 10 seconds for a takeoff to complete...
+//
+//	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+//	defer cancel()
+//	err := drone.WaitForPhase(ctx, tello.PhaseHovering)
+func (tello *Tello) WaitForPhase(ctx context.Context, phases ...FlightPhase) error {
+	for {
+		tello.phaseMu.Lock()
+		current := tello.phase
+		if tello.phaseChanged == nil {
+			tello.phaseChanged = make(chan struct{})
+		}
+		changed := tello.phaseChanged
+		tello.phaseMu.Unlock()
+		for _, p := range phases {
+			if p == current {
+				return nil
+			}
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
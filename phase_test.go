@@ -0,0 +1,83 @@
+// phase_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNextPhase(t *testing.T) {
+	steps := []struct {
+		in   phaseInputs
+		want FlightPhase
+	}{
+		{phaseInputs{}, PhaseGrounded},
+		{phaseInputs{takeoffPending: true}, PhaseTakingOff},
+		{phaseInputs{flying: true, vSpeed: 3}, PhaseTakingOff},
+		{phaseInputs{flying: true, hover: true, vSpeed: 2}, PhaseTakingOff},
+		{phaseInputs{flying: true, hover: true}, PhaseHovering},
+		{phaseInputs{flying: true}, PhaseFlying},
+		{phaseInputs{flying: true, landing: true}, PhaseLanding},
+		{phaseInputs{}, PhaseLanding},
+		{phaseInputs{onGround: true}, PhaseLanded},
+		{phaseInputs{}, PhaseLanded},
+		{phaseInputs{throwPending: true}, PhaseThrowReady},
+		{phaseInputs{flying: true, hover: true}, PhaseHovering},
+		{phaseInputs{flying: true, landing: true, palmLanding: true}, PhasePalmLanding},
+		{phaseInputs{}, PhaseLanded},
+	}
+	phase := PhaseUnknown
+	for i, step := range steps {
+		phase = nextPhase(phase, step.in)
+		if phase != step.want {
+			t.Errorf("Step %d: expected %s, got %s", i, step.want, phase)
+		}
+	}
+	if nextPhase(PhaseUnknown, phaseInputs{flying: true}) != PhaseFlying {
+		t.Error("Expected connecting mid-flight to give Flying")
+	}
+}
+
+func TestWaitForPhase(t *testing.T) {
+	var tello Tello
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		tello.fdMu.Lock()
+		tello.fd.Flying = true
+		tello.fd.DroneHover = true
+		tello.fdMu.Unlock()
+		tello.updatePhase()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := tello.WaitForPhase(ctx, PhaseHovering, PhaseFlying); err != nil {
+		t.Errorf("Expected phase change, got %v", err)
+	}
+
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel2()
+	if err := tello.WaitForPhase(ctx2, PhaseLanded); err != context.DeadlineExceeded {
+		t.Errorf("Expected timeout, got %v", err)
+	}
+}
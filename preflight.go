@@ -0,0 +1,233 @@
+// preflight.go
+
+// This file contains the pre-flight checklist.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Pre-flight check limits...
+const (
+	PreflightFailBatteryPct = 20 // battery below this fails the check
+	PreflightWarnBatteryPct = 40 // battery below this gives a warning
+	PreflightFailWifi       = 30 // Wifi strength below this fails the check
+	PreflightWarnWifi       = 60 // Wifi strength below this gives a warning
+	PreflightWarnWifiInterf = 50 // Wifi interference above this gives a warning
+	PreflightWarnMaxHeightM = 5  // a height limit below this gives a warning as it leaves little room to climb
+	// PreflightValidity is how long a passing check permits TakeOff() when SetPreflightRequired() is in force.
+	PreflightValidity = 5 * time.Minute
+)
+
+// CheckStatus is the outcome of a single pre-flight check item.
+type CheckStatus int
+
+// Check outcomes...
+const (
+	CheckPass CheckStatus = iota
+	CheckWarn
+	CheckFail
+)
+
+func (cs CheckStatus) String() string {
+	switch cs {
+	case CheckPass:
+		return "Pass"
+	case CheckWarn:
+		return "Warn"
+	case CheckFail:
+		return "Fail"
+	}
+	return fmt.Sprintf("Status %d", int(cs))
+}
+
+// CheckItem is the result of checking one aspect of the drone.
+type CheckItem struct {
+	Name   string
+	Status CheckStatus
+	Detail string
+}
+
+// PreflightReport holds the results of a PreflightCheck().
+// Go is true if no item failed.
+type PreflightReport struct {
+	When  time.Time
+	Items []CheckItem
+	Go    bool
+}
+
+// String returns a multi-line printable version of the report.
+func (pr PreflightReport) String() string {
+	var sb strings.Builder
+	for _, item := range pr.Items {
+		fmt.Fprintf(&sb, "%-18s %-4s %s\n", item.Name, item.Status, item.Detail)
+	}
+	if pr.Go {
+		sb.WriteString("GO\n")
+	} else {
+		sb.WriteString("NO-GO\n")
+	}
+	return sb.String()
+}
+
+// PreflightCheck requests fresh status values from the drone, waiting up to timeout for them,
+// and returns a report with a pass, warn or fail for each item and an overall go/no-go.
+// Values which do not arrive in time fail their checks.
+// The report is also retained for SetPreflightRequired().
+func (tello *Tello) PreflightCheck(timeout time.Duration) (report PreflightReport, err error) {
+	if !tello.ControlConnected() {
+		return report, errors.New("Cannot run pre-flight check when not connected")
+	}
+	start := time.Now()
+	tello.GetVersion()
+	tello.GetMaxHeight()
+	missing := tello.awaitResponses(start, timeout, msgFlightStatus, msgLightStrength, msgWifiStrength, msgQueryVersion, msgQueryHeightLimit)
+	fresh := map[uint16]bool{msgFlightStatus: true, msgLightStrength: true, msgWifiStrength: true, msgQueryVersion: true, msgQueryHeightLimit: true}
+	for _, id := range missing {
+		fresh[id] = false
+	}
+	report = evaluatePreflight(tello.GetFlightData(), fresh)
+	tello.preflightMu.Lock()
+	tello.preflight = &report
+	tello.preflightMu.Unlock()
+	return report, nil
+}
+
+// SetPreflightRequired makes TakeOff() and ThrowTakeOff() refuse to run unless a PreflightCheck()
+// has returned a 'go' within the last PreflightValidity.
+func (tello *Tello) SetPreflightRequired(required bool) {
+	tello.preflightMu.Lock()
+	tello.preflightRequired = required
+	tello.preflightMu.Unlock()
+}
+
+// checkPreflight returns an error if a pre-flight check is required but has not been passed recently.
+func (tello *Tello) checkPreflight() error {
+	tello.preflightMu.RLock()
+	defer tello.preflightMu.RUnlock()
+	switch {
+	case !tello.preflightRequired:
+		return nil
+	case tello.preflight == nil || time.Since(tello.preflight.When) > PreflightValidity:
+		return errors.New("A recent pre-flight check is required before takeoff")
+	case !tello.preflight.Go:
+		return errors.New("Pre-flight check failed")
+	}
+	return nil
+}
+
+// evaluatePreflight builds a report from the flight data, fresh indicates which message types have been received.
+func evaluatePreflight(fd FlightData, fresh map[uint16]bool) (report PreflightReport) {
+	report.When = time.Now()
+	add := func(name string, status CheckStatus, format string, args ...interface{}) {
+		report.Items = append(report.Items, CheckItem{Name: name, Status: status, Detail: fmt.Sprintf(format, args...)})
+	}
+	stale := func(name string, msgID uint16) bool {
+		if !fresh[msgID] {
+			add(name, CheckFail, "no fresh data received")
+			return true
+		}
+		return false
+	}
+
+	if !stale("Battery", msgFlightStatus) {
+		switch {
+		case fd.BatteryCritical || fd.BatteryPercentage < PreflightFailBatteryPct:
+			add("Battery", CheckFail, "%d%% (%dmV)", fd.BatteryPercentage, fd.BatteryMilliVolts)
+		case fd.BatteryLow || fd.BatteryPercentage < PreflightWarnBatteryPct:
+			add("Battery", CheckWarn, "%d%% (%dmV)", fd.BatteryPercentage, fd.BatteryMilliVolts)
+		default:
+			add("Battery", CheckPass, "%d%% (%dmV)", fd.BatteryPercentage, fd.BatteryMilliVolts)
+		}
+	}
+	if !stale("IMU", msgFlightStatus) {
+		if fd.ImuState {
+			add("IMU", CheckPass, "OK")
+		} else {
+			add("IMU", CheckFail, "IMU state reported as bad")
+		}
+	}
+	if !stale("IMU Calibration", msgFlightStatus) {
+		if fd.ImuCalibrationState == 0 {
+			add("IMU Calibration", CheckPass, "OK")
+		} else {
+			add("IMU Calibration", CheckWarn, "calibration state %d", fd.ImuCalibrationState)
+		}
+	}
+	if !stale("Pressure Sensor", msgFlightStatus) {
+		if fd.PressureState {
+			add("Pressure Sensor", CheckPass, "OK")
+		} else {
+			add("Pressure Sensor", CheckFail, "pressure state reported as bad")
+		}
+	}
+	if !stale("Error State", msgFlightStatus) {
+		if fd.ErrorState {
+			add("Error State", CheckFail, "drone reports an error")
+		} else {
+			add("Error State", CheckPass, "no error")
+		}
+	}
+	if !stale("Light Strength", msgLightStrength) {
+		if fd.LightStrength == 1 {
+			add("Light Strength", CheckWarn, "too low for reliable positioning")
+		} else {
+			add("Light Strength", CheckPass, "OK")
+		}
+	}
+	if !stale("Wifi", msgWifiStrength) {
+		switch {
+		case fd.WifiStrength < PreflightFailWifi:
+			add("Wifi", CheckFail, "strength %d, interference %d", fd.WifiStrength, fd.WifiInterference)
+		case fd.WifiStrength < PreflightWarnWifi || fd.WifiInterference > PreflightWarnWifiInterf:
+			add("Wifi", CheckWarn, "strength %d, interference %d", fd.WifiStrength, fd.WifiInterference)
+		default:
+			add("Wifi", CheckPass, "strength %d, interference %d", fd.WifiStrength, fd.WifiInterference)
+		}
+	}
+	if !stale("Firmware Version", msgQueryVersion) {
+		if v := strings.TrimRight(fd.Version, "\x00"); v != "" {
+			add("Firmware Version", CheckPass, "%s", v)
+		} else {
+			add("Firmware Version", CheckWarn, "empty version string")
+		}
+	}
+	if !stale("Max Height", msgQueryHeightLimit) {
+		if fd.MaxHeight < PreflightWarnMaxHeightM {
+			add("Max Height", CheckWarn, "%dm", fd.MaxHeight)
+		} else {
+			add("Max Height", CheckPass, "%dm", fd.MaxHeight)
+		}
+	}
+
+	report.Go = true
+	for _, item := range report.Items {
+		if item.Status == CheckFail {
+			report.Go = false
+		}
+	}
+	return report
+}
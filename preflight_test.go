@@ -0,0 +1,80 @@
+// preflight_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEvaluatePreflight(t *testing.T) {
+	fd := FlightData{BatteryPercentage: 90, ImuState: true, PressureState: true, LightStrength: 0,
+		WifiStrength: 90, Version: "01.04.92.01\x00", MaxHeight: 10}
+	fresh := map[uint16]bool{msgFlightStatus: true, msgLightStrength: true, msgWifiStrength: true, msgQueryVersion: true, msgQueryHeightLimit: true}
+	report := evaluatePreflight(fd, fresh)
+	if !report.Go || len(report.Items) != 9 {
+		t.Fatalf("Expected a 9-item go report, got\n%s", report)
+	}
+	for _, item := range report.Items {
+		if item.Status != CheckPass {
+			t.Errorf("Expected %s to pass, got %s", item.Name, item.Status)
+		}
+	}
+
+	fd.MaxHeight = 3
+	report = evaluatePreflight(fd, fresh)
+	if !report.Go || report.Items[len(report.Items)-1].Status != CheckWarn {
+		t.Errorf("Expected max height warning but still go, got\n%s", report)
+	}
+	fd.MaxHeight = 10
+
+	fd.BatteryPercentage = 30
+	report = evaluatePreflight(fd, fresh)
+	if !report.Go || report.Items[0].Status != CheckWarn {
+		t.Errorf("Expected battery warning but still go, got\n%s", report)
+	}
+
+	fresh[msgWifiStrength] = false
+	report = evaluatePreflight(fd, fresh)
+	if report.Go {
+		t.Errorf("Expected no-go without fresh Wifi data, got\n%s", report)
+	}
+}
+
+func TestCheckPreflight(t *testing.T) {
+	var tello Tello
+	if err := tello.checkPreflight(); err != nil {
+		t.Errorf("Expected no requirement by default, got %v", err)
+	}
+	tello.SetPreflightRequired(true)
+	if err := tello.checkPreflight(); err == nil {
+		t.Error("Expected takeoff to be refused without a check")
+	}
+	tello.preflight = &PreflightReport{When: time.Now(), Go: true}
+	if err := tello.checkPreflight(); err != nil {
+		t.Errorf("Expected recent passing check to be accepted, got %v", err)
+	}
+	tello.preflight.When = time.Now().Add(-PreflightValidity - time.Second)
+	if err := tello.checkPreflight(); err == nil {
+		t.Error("Expected stale check to be refused")
+	}
+}
@@ -0,0 +1,143 @@
+// restrictions.go
+
+// This file contains the restrictions profile used eg. for training.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+)
+
+// Restrictions limit what may be done with the drone, eg. when teaching students.
+// Zero values mean that limit is not applied.
+type Restrictions struct {
+	MaxStickPct       int     // all stick values are scaled so that full deflection gives this percentage (1-100)
+	BlockFlips        bool    // refuse Flip() and the flip macros
+	BlockBounce       bool    // refuse Bounce()
+	BlockSportsMode   bool    // refuse SetSportsMode(true), SetFastMode()
+	BlockThrowTakeOff bool    // refuse ThrowTakeOff()
+	CeilingM          float32 // maximum height, in addition to any geofence
+	MaxRadiusM        float32 // maximum horizontal distance from home, in addition to any geofence
+}
+
+// TrainingRestrictions returns a profile suitable for a classroom.
+func TrainingRestrictions() Restrictions {
+	return Restrictions{
+		MaxStickPct:       40,
+		BlockFlips:        true,
+		BlockBounce:       true,
+		BlockSportsMode:   true,
+		BlockThrowTakeOff: true,
+		CeilingM:          2,
+	}
+}
+
+// SetRestrictions applies a restrictions profile, it may be changed at any time.
+// Sports mode is switched off if the profile blocks it.
+// The height and radius limits behave as a FencePushBack geofence, combined with any geofence
+// that has been set; a ceiling at or below the floor of the current geofence is refused.
+func (tello *Tello) SetRestrictions(r Restrictions) error {
+	if r.MaxStickPct < 0 || r.MaxStickPct > 100 {
+		return errors.New("Restriction stick percentage must be between 0 and 100")
+	}
+	if r.CeilingM < 0 || r.MaxRadiusM < 0 {
+		return errors.New("Restriction limits must not be negative")
+	}
+	if fence, set := tello.GetGeofence(); set && r.CeilingM > 0 && fence.FloorM >= r.CeilingM {
+		return errors.New("Restriction ceiling must be above the geofence floor")
+	}
+	tello.ctrlMu.Lock()
+	tello.restrictMu.Lock()
+	tello.restrict = &r
+	tello.restrictMu.Unlock()
+	if r.BlockSportsMode {
+		tello.ctrlSportsMode = false
+	}
+	tello.ctrlMu.Unlock()
+	return nil
+}
+
+// ClearRestrictions removes any restrictions profile.
+func (tello *Tello) ClearRestrictions() {
+	tello.restrictMu.Lock()
+	tello.restrict = nil
+	tello.restrictMu.Unlock()
+}
+
+// GetRestrictions returns the current restrictions profile and whether one is set.
+func (tello *Tello) GetRestrictions() (r Restrictions, set bool) {
+	tello.restrictMu.RLock()
+	defer tello.restrictMu.RUnlock()
+	if tello.restrict == nil {
+		return r, false
+	}
+	return *tello.restrict, true
+}
+
+// checkRestricted returns an error if the profile's blocked func reports true.
+func (tello *Tello) checkRestricted(blocked func(Restrictions) bool, what string) error {
+	if r, set := tello.GetRestrictions(); set && blocked(r) {
+		return errors.New(what + " is blocked by the current restrictions")
+	}
+	return nil
+}
+
+// restrictSticks scales the stick values according to any restrictions profile.
+func (tello *Tello) restrictSticks(rx, ry, lx, ly int16) (int16, int16, int16, int16) {
+	r, set := tello.GetRestrictions()
+	if !set || r.MaxStickPct == 0 {
+		return rx, ry, lx, ly
+	}
+	scale := func(v int16) int16 { return int16(int32(v) * int32(r.MaxStickPct) / 100) }
+	return scale(rx), scale(ry), scale(lx), scale(ly)
+}
+
+// restrictFence combines any restrictions with a geofence.
+// The restriction ceiling takes precedence, so should it not be above the fence's floor
+// the floor is dropped rather than leaving no permitted height.
+func (r Restrictions) restrictFence(fence Geofence, set bool) (Geofence, bool) {
+	if r.CeilingM == 0 && r.MaxRadiusM == 0 {
+		return fence, set
+	}
+	if !set {
+		fence = Geofence{Action: FencePushBack}
+	}
+	if r.CeilingM > 0 && (fence.CeilingM == 0 || r.CeilingM < fence.CeilingM) {
+		fence.CeilingM = r.CeilingM
+		if fence.FloorM >= fence.CeilingM {
+			fence.FloorM = 0
+		}
+	}
+	if r.MaxRadiusM > 0 && (fence.MaxRadiusM == 0 || r.MaxRadiusM < fence.MaxRadiusM) {
+		fence.MaxRadiusM = r.MaxRadiusM
+	}
+	return fence, true
+}
+
+// effectiveFence returns the geofence combined with any restrictions.
+func (tello *Tello) effectiveFence() (fence Geofence, set bool) {
+	fence, set = tello.GetGeofence()
+	if r, rset := tello.GetRestrictions(); rset {
+		return r.restrictFence(fence, set)
+	}
+	return fence, set
+}
@@ -0,0 +1,92 @@
+// restrictions_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"testing"
+)
+
+func TestRestrictions(t *testing.T) {
+	var tello Tello
+	if err := tello.SetRestrictions(Restrictions{MaxStickPct: 150}); err == nil {
+		t.Error("Expected invalid stick percentage to be rejected")
+	}
+	tello.ctrlSportsMode = true
+	tello.SetRestrictions(TrainingRestrictions())
+	if tello.ctrlSportsMode {
+		t.Error("Expected sports mode to be switched off")
+	}
+	if err := tello.SetFastMode(); err == nil {
+		t.Error("Expected sports mode to be blocked")
+	}
+	if err := tello.BackFlip(); err == nil {
+		t.Error("Expected flips to be blocked")
+	}
+	if err := tello.ThrowTakeOff(); err == nil {
+		t.Error("Expected throw takeoff to be blocked")
+	}
+	if rx, ry, lx, ly := tello.restrictSticks(32767, -32768, 1000, 0); rx != 13106 || ry != -13107 || lx != 400 || ly != 0 {
+		t.Errorf("Unexpected scaled sticks %d %d %d %d", rx, ry, lx, ly)
+	}
+	tello.ClearRestrictions()
+	if err := tello.SetSlowMode(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestRestrictFence(t *testing.T) {
+	r := Restrictions{CeilingM: 2, MaxRadiusM: 5}
+	fence, set := r.restrictFence(Geofence{}, false)
+	if !set || fence.CeilingM != 2 || fence.MaxRadiusM != 5 || fence.Action != FencePushBack {
+		t.Errorf("Unexpected fence from restrictions alone %v", fence)
+	}
+	fence, _ = r.restrictFence(Geofence{CeilingM: 1, MaxRadiusM: 10, Action: FenceLand}, true)
+	if fence.CeilingM != 1 || fence.MaxRadiusM != 5 || fence.Action != FenceLand {
+		t.Errorf("Expected tightest limits to be combined, got %v", fence)
+	}
+	if _, set = (Restrictions{MaxStickPct: 50}).restrictFence(Geofence{}, false); set {
+		t.Error("Expected no fence without height or radius restrictions")
+	}
+	fence, _ = (Restrictions{CeilingM: 1}).restrictFence(Geofence{CeilingM: 3, FloorM: 1.5}, true)
+	if fence.CeilingM != 1 || fence.FloorM != 0 {
+		t.Errorf("Expected floor to be dropped below the restriction ceiling, got %v", fence)
+	}
+}
+
+func TestRestrictionCeilingBelowFloor(t *testing.T) {
+	var tello Tello
+	if err := tello.SetGeofence(Geofence{CeilingM: 3, FloorM: 1.5}); err != nil {
+		t.Fatalf("SetGeofence failed with error %v", err)
+	}
+	if err := tello.SetRestrictions(Restrictions{CeilingM: 1}); err == nil {
+		t.Error("Expected restriction ceiling below the geofence floor to be rejected")
+	}
+	if err := tello.SetRestrictions(Restrictions{CeilingM: 2}); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if err := tello.SetGeofence(Geofence{CeilingM: 3, FloorM: 2}); err == nil {
+		t.Error("Expected geofence floor at the restriction ceiling to be rejected")
+	}
+	if fence, _ := tello.effectiveFence(); fence.FloorM >= fence.CeilingM {
+		t.Errorf("Expected floor below ceiling, got %v", fence)
+	}
+}
@@ -0,0 +1,337 @@
+// shapes.go
+
+// This file contains generators for waypoint paths of common shapes, and a func to fly them.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// shapeSegmentsPerTurn is the number of waypoints used for each turn of curved shapes.
+const shapeSegmentsPerTurn = 16
+
+const (
+	// AutoShapeSpeedMS is the maximum speed in m/s at which AutoFlyShape() flies.
+	AutoShapeSpeedMS = 0.5
+	// AutoShapeAccelMS2 is the maximum acceleration in m/s/s with which AutoFlyShape() flies.
+	AutoShapeAccelMS2 = 0.5
+)
+
+// All the shape generators below return paths in the home frame for use with AutoFlyShape(),
+// AutoFlyPath() or NewTrajectory().  Shapes are flown horizontally around the given centre (whose Z is the
+// height in metres) and rotationDeg turns the whole shape clockwise, viewed from above, about its centre.
+
+// PolygonPath returns a closed regular polygon with the given number of sides whose corners
+// lie radius metres from the centre.  The first corner is directly 'north' (+Y) of the centre
+// before rotation, and the path ends back at the first corner.
+func PolygonPath(centre Point3D, radius float32, sides int, rotationDeg float32) ([]Point3D, error) {
+	if sides < 3 {
+		return nil, errors.New("A polygon must have at least 3 sides")
+	}
+	if radius <= 0 {
+		return nil, errors.New("Shape size must be positive")
+	}
+	path := make([]Point3D, 0, sides+1)
+	for i := 0; i <= sides; i++ {
+		theta := 2 * math.Pi * float64(i%sides) / float64(sides)
+		path = append(path, shapePoint(centre, rotationDeg, radius*float32(math.Sin(theta)), radius*float32(math.Cos(theta)), 0))
+	}
+	return path, nil
+}
+
+// SquarePath returns a closed square of the given side length in metres, with its sides aligned
+// to the home frame axes before rotation.  The path starts and ends at the front-left corner.
+func SquarePath(centre Point3D, side float32, rotationDeg float32) ([]Point3D, error) {
+	if side <= 0 {
+		return nil, errors.New("Shape size must be positive")
+	}
+	h := side / 2
+	return []Point3D{
+		shapePoint(centre, rotationDeg, -h, h, 0),
+		shapePoint(centre, rotationDeg, h, h, 0),
+		shapePoint(centre, rotationDeg, h, -h, 0),
+		shapePoint(centre, rotationDeg, -h, -h, 0),
+		shapePoint(centre, rotationDeg, -h, h, 0),
+	}, nil
+}
+
+// CirclePath returns a closed circle of the given radius in metres, flown clockwise from
+// the point directly 'north' (+Y) of the centre before rotation.
+func CirclePath(centre Point3D, radius float32, rotationDeg float32) ([]Point3D, error) {
+	return HelixPath(centre, radius, 0, 1, rotationDeg)
+}
+
+// FigureEightPath returns a figure-eight of the given overall length in metres along the (unrotated)
+// X axis, with each loop length/2 across.  The path starts and ends at the centre.
+func FigureEightPath(centre Point3D, length float32, rotationDeg float32) ([]Point3D, error) {
+	if length <= 0 {
+		return nil, errors.New("Shape size must be positive")
+	}
+	// lemniscate of Gerono: x = a.sin(t), y = a.sin(t).cos(t)
+	a := float64(length) / 2
+	n := 2 * shapeSegmentsPerTurn
+	path := make([]Point3D, 0, n+1)
+	for i := 0; i <= n; i++ {
+		t := 2 * math.Pi * float64(i) / float64(n)
+		path = append(path, shapePoint(centre, rotationDeg, float32(a*math.Sin(t)), float32(a*math.Sin(t)*math.Cos(t)), 0))
+	}
+	return path, nil
+}
+
+// SpiralPath returns a clockwise spiral which starts startRadius metres 'north' (+Y) of the
+// centre before rotation and whose radius changes steadily to endRadius over the given number
+// of turns, while climbing by climb metres (which may be negative to descend).
+func SpiralPath(centre Point3D, startRadius, endRadius, climb, turns float32, rotationDeg float32) ([]Point3D, error) {
+	if startRadius < 0 || endRadius < 0 || (startRadius == 0 && endRadius == 0) {
+		return nil, errors.New("Shape size must be positive")
+	}
+	if turns <= 0 {
+		return nil, errors.New("Number of turns must be positive")
+	}
+	if centre.Z+climb < 0 {
+		return nil, errors.New("Shape would descend below the ground")
+	}
+	n := int(math.Ceil(float64(turns) * shapeSegmentsPerTurn))
+	path := make([]Point3D, 0, n+1)
+	for i := 0; i <= n; i++ {
+		frac := float32(i) / float32(n)
+		theta := 2 * math.Pi * float64(turns*frac)
+		r := startRadius + (endRadius-startRadius)*frac
+		path = append(path, shapePoint(centre, rotationDeg, r*float32(math.Sin(theta)), r*float32(math.Cos(theta)), climb*frac))
+	}
+	return path, nil
+}
+
+// HelixPath returns a clockwise helix of constant radius which climbs by climb metres over
+// the given number of turns, starting directly 'north' (+Y) of the centre before rotation.
+func HelixPath(centre Point3D, radius, climb, turns float32, rotationDeg float32) ([]Point3D, error) {
+	if radius <= 0 {
+		return nil, errors.New("Shape size must be positive")
+	}
+	return SpiralPath(centre, radius, radius, climb, turns, rotationDeg)
+}
+
+// shapePoint offsets a point, given relative to the centre of an unrotated shape, into the home frame.
+func shapePoint(centre Point3D, rotationDeg float32, dx, dy, dz float32) Point3D {
+	rot := float64(rotationDeg) * math.Pi / 180
+	sin, cos := float32(math.Sin(rot)), float32(math.Cos(rot))
+	return Point3D{
+		X: centre.X + dx*cos + dy*sin,
+		Y: centre.Y - dx*sin + dy*cos,
+		Z: centre.Z + dz,
+	}
+}
+
+// headingTo returns the yaw in degrees (-180 to +180) that faces along the given home frame displacement.
+func headingTo(dx, dy float32) int16 {
+	return int16(math.Round(math.Atan2(float64(dx), float64(dy)) * 180 / math.Pi))
+}
+
+// AutoFlyShape flies smoothly along a shape's path, as returned by one of the generators above,
+// without stopping at each waypoint.  The drone flies from its current position to the start
+// of the shape and then around it as a single trajectory, so sharp corners are slightly rounded.
+// See AutoFlyTrajectory() for details; the flight may be cancelled via CancelAutoFlyTrajectory().
+func (tello *Tello) AutoFlyShape(path []Point3D) (done chan bool, err error) {
+	if len(path) == 0 {
+		return nil, errors.New("Path has no waypoints")
+	}
+	if !tello.IsHomeSet() {
+		return nil, errors.New("Cannot AutoFly as home point has not be set (or is invalid)")
+	}
+	x, y, z, _ := tello.homeRelativePos()
+	traj, err := NewTrajectory(append([]Point3D{{X: x, Y: y, Z: z}}, path...), AutoShapeSpeedMS, AutoShapeAccelMS2)
+	if err != nil {
+		return nil, err
+	}
+	return tello.AutoFlyTrajectory(traj)
+}
+
+// AutoFlyPolygon generates and flies a PolygonPath() in a single call.
+func (tello *Tello) AutoFlyPolygon(centre Point3D, radius float32, sides int, rotationDeg float32) (done chan bool, err error) {
+	path, err := PolygonPath(centre, radius, sides, rotationDeg)
+	if err != nil {
+		return nil, err
+	}
+	return tello.AutoFlyShape(path)
+}
+
+// AutoFlySquare generates and flies a SquarePath() in a single call.
+func (tello *Tello) AutoFlySquare(centre Point3D, side float32, rotationDeg float32) (done chan bool, err error) {
+	path, err := SquarePath(centre, side, rotationDeg)
+	if err != nil {
+		return nil, err
+	}
+	return tello.AutoFlyShape(path)
+}
+
+// AutoFlyCircle generates and flies a CirclePath() in a single call.
+func (tello *Tello) AutoFlyCircle(centre Point3D, radius float32, rotationDeg float32) (done chan bool, err error) {
+	path, err := CirclePath(centre, radius, rotationDeg)
+	if err != nil {
+		return nil, err
+	}
+	return tello.AutoFlyShape(path)
+}
+
+// AutoFlyFigureEight generates and flies a FigureEightPath() in a single call.
+func (tello *Tello) AutoFlyFigureEight(centre Point3D, length float32, rotationDeg float32) (done chan bool, err error) {
+	path, err := FigureEightPath(centre, length, rotationDeg)
+	if err != nil {
+		return nil, err
+	}
+	return tello.AutoFlyShape(path)
+}
+
+// AutoFlySpiral generates and flies a SpiralPath() in a single call.
+func (tello *Tello) AutoFlySpiral(centre Point3D, startRadius, endRadius, climb, turns float32, rotationDeg float32) (done chan bool, err error) {
+	path, err := SpiralPath(centre, startRadius, endRadius, climb, turns, rotationDeg)
+	if err != nil {
+		return nil, err
+	}
+	return tello.AutoFlyShape(path)
+}
+
+// AutoFlyHelix generates and flies a HelixPath() in a single call.
+func (tello *Tello) AutoFlyHelix(centre Point3D, radius, climb, turns float32, rotationDeg float32) (done chan bool, err error) {
+	path, err := HelixPath(centre, radius, climb, turns, rotationDeg)
+	if err != nil {
+		return nil, err
+	}
+	return tello.AutoFlyShape(path)
+}
+
+// CancelAutoFlyPath stops any in-flight AutoFlyPath navigation.
+// The drone should stop.
+func (tello *Tello) CancelAutoFlyPath() {
+	tello.autoPathMu.Lock()
+	tello.autoPath = false
+	tello.autoPathMu.Unlock()
+}
+
+// IsAutoPath tests whether we are currently flying a path.
+func (tello *Tello) IsAutoPath() (set bool) {
+	tello.autoPathMu.RLock()
+	set = tello.autoPath
+	tello.autoPathMu.RUnlock()
+	return set
+}
+
+// AutoFlyPath flies to each of the waypoints in turn, using AutoFlyToHeight() and
+// AutoFlyToXY() concurrently for each leg.  The waypoints are in the home frame, so the
+// home point must have been previously set.
+// If faceForward is set then the drone first turns (via AutoTurnToYaw()) to face along each leg.
+// The func returns immediately and a Goroutine handles the navigation until either
+// it is complete or cancelled via CancelAutoFlyPath().
+// The caller may optionally listen on the 'done' channel for a signal that
+// the navigation is complete (or has been cancelled).
+func (tello *Tello) AutoFlyPath(path []Point3D, faceForward bool) (done chan bool, err error) {
+	if err = tello.checkArmed(); err != nil {
+		return nil, err
+	}
+	if tello.IsAutoTuning() {
+		return nil, errAutoTuning
+	}
+	if len(path) == 0 {
+		return nil, errors.New("Path has no waypoints")
+	}
+	for _, wp := range path {
+		if wp.X > AutoXYLimitM || wp.Y > AutoXYLimitM || wp.X < -AutoXYLimitM || wp.Y < -AutoXYLimitM {
+			return nil, errors.New("Horizontal navigation limit exceeded")
+		}
+		if wp.Z*10 > AutoHeightLimitDm || wp.Z < 0 {
+			return nil, errors.New("Verical navigation limit exceeded")
+		}
+	}
+	if tello.IsAutoPath() {
+		return nil, errors.New("Already flying a path")
+	}
+	if tello.IsAutoXY() || tello.isAutoHeight() || tello.IsAutoTurning() || tello.IsAutoTrajectory() || tello.IsAutoFollowing() {
+		return nil, errors.New("Cannot fly a path during other automatic flight")
+	}
+	if !tello.IsHomeSet() {
+		return nil, errors.New("Cannot AutoFly as home point has not be set (or is invalid)")
+	}
+	for _, wp := range path {
+		if !tello.fenceAllowsXY(wp.X, wp.Y) || !tello.fenceAllowsHeight(wp.Z) {
+			return nil, errors.New("Path passes outside the geofence")
+		}
+	}
+
+	tello.autoPathMu.Lock()
+	tello.autoPath = true
+	tello.autoPathMu.Unlock()
+
+	done = make(chan bool, 1) // buffered so send doesn't block
+
+	go func() {
+		tol := tello.GetAutopilotGains().XYToleranceM
+		for _, wp := range path {
+			if faceForward {
+				x, y, _, _ := tello.homeRelativePos()
+				if dx, dy := wp.X-x, wp.Y-y; dx*dx+dy*dy > tol*tol {
+					if !tello.awaitPathLeg(tello.AutoTurnToYaw(headingTo(dx, dy))) {
+						break
+					}
+				}
+			}
+			hDone, err := tello.AutoFlyToHeight(int16(math.Round(float64(wp.Z * 10))))
+			if err != nil {
+				break
+			}
+			if !tello.awaitPathLeg(tello.AutoFlyToXY(wp.X, wp.Y)) {
+				tello.CancelAutoFlyToHeight()
+				<-hDone
+				break
+			}
+			if !tello.awaitPathLeg(hDone, nil) {
+				break
+			}
+		}
+		tello.CancelAutoFlyPath()
+		done <- true
+	}()
+
+	return done, nil
+}
+
+// awaitPathLeg waits for an autopilot started by AutoFlyPath to finish, cancelling it if the
+// path is cancelled.  It returns false if the leg could not be started or the path was cancelled.
+func (tello *Tello) awaitPathLeg(legDone chan bool, err error) bool {
+	if err != nil {
+		return false
+	}
+	for {
+		select {
+		case <-legDone:
+			return tello.IsAutoPath()
+		case <-time.After(autopilotPeriodMs * time.Millisecond):
+			if !tello.IsAutoPath() {
+				tello.CancelAutoTurn()
+				tello.CancelAutoFlyToXY()
+				tello.CancelAutoFlyToHeight()
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// shapes_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPolygonPath(t *testing.T) {
+	if _, err := PolygonPath(Point3D{}, 1, 2, 0); err == nil {
+		t.Error("Expected error for 2-sided polygon")
+	}
+	path, err := PolygonPath(Point3D{X: 1, Y: 1, Z: 1}, 2, 6, 0)
+	if err != nil {
+		t.Fatalf("PolygonPath failed with error %v", err)
+	}
+	if len(path) != 7 || path[0] != path[6] {
+		t.Errorf("Expected closed path of 7 points, got %v", path)
+	}
+	for _, p := range path {
+		if r := math.Hypot(float64(p.X-1), float64(p.Y-1)); math.Abs(r-2) > 0.0001 || p.Z != 1 {
+			t.Errorf("Point %v is not on the polygon", p)
+		}
+	}
+}
+
+func TestSquarePath(t *testing.T) {
+	path, err := SquarePath(Point3D{Z: 1}, 2, 90)
+	if err != nil {
+		t.Fatalf("SquarePath failed with error %v", err)
+	}
+	// rotated 90 degrees clockwise the front-left corner is now front-right
+	if math.Abs(float64(path[0].X-1)) > 0.0001 || math.Abs(float64(path[0].Y-1)) > 0.0001 {
+		t.Errorf("Expected first corner at (1, 1), got %v", path[0])
+	}
+	if len(path) != 5 {
+		t.Errorf("Expected 5 points, got %d", len(path))
+	}
+}
+
+func TestFigureEightPath(t *testing.T) {
+	path, err := FigureEightPath(Point3D{Z: 1.5}, 4, 0)
+	if err != nil {
+		t.Fatalf("FigureEightPath failed with error %v", err)
+	}
+	first, last := path[0], path[len(path)-1]
+	if math.Abs(float64(first.X-last.X)) > 0.0001 || math.Abs(float64(first.Y-last.Y)) > 0.0001 {
+		t.Errorf("Expected path to start and end at the centre, got %v & %v", first, last)
+	}
+	var maxX float32
+	for _, p := range path {
+		if p.X > maxX {
+			maxX = p.X
+		}
+	}
+	if math.Abs(float64(maxX-2)) > 0.0001 {
+		t.Errorf("Expected half-length of 2, got %f", maxX)
+	}
+}
+
+func TestSpiralAndHelixPaths(t *testing.T) {
+	if _, err := SpiralPath(Point3D{Z: 1}, 1, 2, -2, 1, 0); err == nil {
+		t.Error("Expected error for descending below ground")
+	}
+	path, err := SpiralPath(Point3D{Z: 1}, 1, 3, 2, 2, 0)
+	if err != nil {
+		t.Fatalf("SpiralPath failed with error %v", err)
+	}
+	last := path[len(path)-1]
+	if math.Abs(float64(last.Y-3)) > 0.0001 || math.Abs(float64(last.Z-3)) > 0.0001 {
+		t.Errorf("Expected spiral to end at (0, 3, 3), got %v", last)
+	}
+	path, err = HelixPath(Point3D{Z: 1}, 1, 1, 1.5, 0)
+	if err != nil {
+		t.Fatalf("HelixPath failed with error %v", err)
+	}
+	for _, p := range path {
+		if r := math.Hypot(float64(p.X), float64(p.Y)); math.Abs(r-1) > 0.0001 {
+			t.Errorf("Point %v is not on the helix", p)
+		}
+	}
+	if _, err = CirclePath(Point3D{Z: 1}, 0, 0); err == nil {
+		t.Error("Expected error for zero radius circle")
+	}
+	path, err = CirclePath(Point3D{Z: 1}, 2, 90)
+	if err != nil {
+		t.Fatalf("CirclePath failed with error %v", err)
+	}
+	if math.Abs(float64(path[0].X-2)) > 0.0001 || math.Abs(float64(path[0].Y)) > 0.0001 {
+		t.Errorf("Expected rotated circle to start at (2, 0), got %v", path[0])
+	}
+}
+
+func TestHeadingTo(t *testing.T) {
+	for _, tc := range []struct {
+		dx, dy float32
+		yaw    int16
+	}{{0, 1, 0}, {1, 0, 90}, {-1, 0, -90}, {0, -1, 180}} {
+		if y := headingTo(tc.dx, tc.dy); y != tc.yaw {
+			t.Errorf("Expected %d for (%f, %f), got %d", tc.yaw, tc.dx, tc.dy, y)
+		}
+		// and the heading must make the displacement straight ahead
+		if dx, dy := calcXYdeltas(tc.yaw, 0, 0, tc.dx, tc.dy); math.Abs(float64(dx)) > 0.0001 || dy <= 0 {
+			t.Errorf("Heading %d does not face (%f, %f)", tc.yaw, tc.dx, tc.dy)
+		}
+	}
+}
+
+func TestAutoFlyShapeRefused(t *testing.T) {
+	var tello Tello
+	if _, err := tello.AutoFlyCircle(Point3D{Z: 1}, 0, 0); err == nil {
+		t.Error("Expected invalid circle to be refused")
+	}
+	if _, err := tello.AutoFlySquare(Point3D{Z: 1}, 2, 45); err == nil {
+		t.Error("Expected shape to be refused without a home point")
+	}
+	if _, err := tello.AutoFlyShape(nil); err == nil {
+		t.Error("Expected empty shape to be refused")
+	}
+}
@@ -0,0 +1,216 @@
+// smartreturn.go
+
+// This file contains the battery-aware 'smart return' estimator.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// smartReturnCheckPeriod is how often the return estimate is recalculated.
+const smartReturnCheckPeriod = time.Second
+
+// SmartReturnConfig controls the battery-aware return estimator.
+type SmartReturnConfig struct {
+	ReservePct         float32       // battery percentage we want left after landing at home
+	ReturnSpeedMS      float32       // expected ground speed on the way home in m/s
+	DescentSpeedMS     float32       // expected descent speed in m/s
+	LandTime           time.Duration // allowance for the landing itself
+	Window             time.Duration // period over which the consumption rate is measured
+	DefaultDrainPctMin float32       // consumption rate (% per minute) assumed until it can be measured
+	MinMilliVolts      int16         // return regardless of estimates if the battery falls below this, 0 to disable
+	FlyTimeLeftUnit    time.Duration // unit of FlightData.DroneFlyTimeLeft for the cross-check, 0 to disable
+	AutoReturn         bool          // call AutoReturnHome(true) when a return is due, otherwise just raise EvReturnNow
+}
+
+// ReturnEstimate is the estimator's most recent assessment.
+type ReturnEstimate struct {
+	DistanceM      float32       // horizontal distance from the home point
+	HeightM        float32       // height above take-off point
+	ReturnTime     time.Duration // estimated time to fly home and land
+	DrainPctPerMin float32       // consumption rate in use
+	NeededPct      float32       // battery needed to get home and land, including the reserve
+	AvailablePct   float32       // battery currently left
+	DroneTimeLeft  time.Duration // the drone's own estimate of flying time left, zero if unavailable
+	ReturnNow      bool          // time to go home
+}
+
+type batterySample struct {
+	when time.Time
+	pct  float32
+}
+
+type smartReturnState struct {
+	cfg       SmartReturnConfig
+	samples   []batterySample
+	lastCheck time.Time
+	estimate  ReturnEstimate
+	valid     bool
+	fired     bool
+}
+
+// DefaultSmartReturnConfig returns a fairly conservative configuration.
+func DefaultSmartReturnConfig() SmartReturnConfig {
+	return SmartReturnConfig{
+		ReservePct:         10,
+		ReturnSpeedMS:      1.0,
+		DescentSpeedMS:     0.5,
+		LandTime:           5 * time.Second,
+		Window:             time.Minute,
+		DefaultDrainPctMin: 10,
+		MinMilliVolts:      3500,
+		FlyTimeLeftUnit:    time.Second,
+	}
+}
+
+// EnableSmartReturn starts continuously estimating the battery needed to fly back to the
+// home point and land.  The estimate is based on the battery percentage and the consumption
+// rate observed over cfg.Window, and is cross-checked against the drone's own estimate in
+// FlightData.DroneFlyTimeLeft: a return is also due once that is no more than the time needed
+// to get home plus the reserve.  N.B. The unit of DroneFlyTimeLeft is not documented; it is
+// taken to be cfg.FlyTimeLeftUnit (seconds by default), so set that to 0 to skip the cross-check
+// if the drone's figure proves unreliable.  When the battery left falls to that level an EvReturnNow event is
+// raised and, if cfg.AutoReturn is set, AutoReturnHome(true) is called.
+// This happens once per flight.
+// The home point must be set for the estimator to do anything.
+func (tello *Tello) EnableSmartReturn(cfg SmartReturnConfig) error {
+	if cfg.ReservePct < 0 || cfg.ReservePct >= 100 {
+		return errors.New("Smart return reserve must be between 0 and 100 percent")
+	}
+	if cfg.ReturnSpeedMS <= 0 || cfg.DescentSpeedMS <= 0 {
+		return errors.New("Smart return speeds must be positive")
+	}
+	if cfg.Window < 10*time.Second {
+		return errors.New("Smart return window must be at least 10 seconds")
+	}
+	if cfg.DefaultDrainPctMin <= 0 {
+		return errors.New("Smart return default consumption rate must be positive")
+	}
+	tello.smartReturnMu.Lock()
+	tello.smartReturn = &smartReturnState{cfg: cfg}
+	tello.smartReturnMu.Unlock()
+	return nil
+}
+
+// DisableSmartReturn stops the return estimator.
+func (tello *Tello) DisableSmartReturn() {
+	tello.smartReturnMu.Lock()
+	tello.smartReturn = nil
+	tello.smartReturnMu.Unlock()
+}
+
+// GetReturnEstimate returns the latest estimate, ok is false if no estimate is available.
+func (tello *Tello) GetReturnEstimate() (est ReturnEstimate, ok bool) {
+	tello.smartReturnMu.Lock()
+	defer tello.smartReturnMu.Unlock()
+	if tello.smartReturn == nil || !tello.smartReturn.valid {
+		return est, false
+	}
+	return tello.smartReturn.estimate, true
+}
+
+// checkSmartReturn is called by keepAlive to update the estimate.
+func (tello *Tello) checkSmartReturn() {
+	tello.smartReturnMu.Lock()
+	sr := tello.smartReturn
+	if sr == nil || time.Since(sr.lastCheck) < smartReturnCheckPeriod {
+		tello.smartReturnMu.Unlock()
+		return
+	}
+	now := time.Now()
+	sr.lastCheck = now
+	tello.fdMu.RLock()
+	flying := tello.fd.Flying
+	pct := float32(tello.fd.BatteryPercentage)
+	mV := tello.fd.BatteryMilliVolts
+	droneLeft := time.Duration(tello.fd.DroneFlyTimeLeft) * sr.cfg.FlyTimeLeftUnit
+	tello.fdMu.RUnlock()
+	if !flying || !tello.IsHomeSet() {
+		sr.samples = nil
+		sr.valid = false
+		sr.fired = false
+		tello.smartReturnMu.Unlock()
+		return
+	}
+	sr.samples = append(sr.samples, batterySample{when: now, pct: pct})
+	for len(sr.samples) > 1 && now.Sub(sr.samples[0].when) > sr.cfg.Window {
+		sr.samples = sr.samples[1:]
+	}
+	x, y, z, _ := tello.homeRelativePos()
+	drain := drainRate(sr.samples, sr.cfg.DefaultDrainPctMin)
+	sr.estimate = estimateReturn(sr.cfg, float32(math.Hypot(float64(x), float64(y))), z, pct, mV, drain, droneLeft)
+	sr.valid = true
+	fire := sr.estimate.ReturnNow && !sr.fired
+	if fire {
+		sr.fired = true
+	}
+	est := sr.estimate
+	autoReturn := sr.cfg.AutoReturn
+	tello.smartReturnMu.Unlock()
+
+	if fire {
+		tello.publishEvent(EvReturnNow, "%.1f%% battery left, %.1f%% needed to return %.1fm", est.AvailablePct, est.NeededPct, est.DistanceM)
+		if autoReturn {
+			go tello.AutoReturnHome(true)
+		}
+	}
+}
+
+// drainRate estimates battery consumption in percent per minute from the samples,
+// falling back to the given default until there is a measurable drop.
+func drainRate(samples []batterySample, defaultPctMin float32) float32 {
+	if len(samples) < 2 {
+		return defaultPctMin
+	}
+	first, last := samples[0], samples[len(samples)-1]
+	mins := float32(last.when.Sub(first.when).Minutes())
+	drop := first.pct - last.pct
+	if mins < 1.0/6 || drop < 2 { // percentages are whole numbers so we need a couple of steps
+		return defaultPctMin
+	}
+	return drop / mins
+}
+
+// estimateReturn calculates what is needed to get home from the given position.
+// droneLeft is the drone's own estimate of flying time left, zero or negative if unavailable.
+func estimateReturn(cfg SmartReturnConfig, distM, heightM, pct float32, mV int16, drainPctMin float32, droneLeft time.Duration) (est ReturnEstimate) {
+	if heightM < 0 {
+		heightM = 0
+	}
+	est.DistanceM = distM
+	est.HeightM = heightM
+	est.DrainPctPerMin = drainPctMin
+	est.AvailablePct = pct
+	secs := distM/cfg.ReturnSpeedMS + heightM/cfg.DescentSpeedMS + float32(cfg.LandTime.Seconds())
+	est.ReturnTime = time.Duration(secs * float32(time.Second))
+	est.NeededPct = secs/60*drainPctMin + cfg.ReservePct
+	est.ReturnNow = pct <= est.NeededPct || (cfg.MinMilliVolts > 0 && mV > 0 && mV < cfg.MinMilliVolts)
+	if droneLeft > 0 {
+		est.DroneTimeLeft = droneLeft
+		reserve := time.Duration(cfg.ReservePct / drainPctMin * float32(time.Minute))
+		est.ReturnNow = est.ReturnNow || droneLeft <= est.ReturnTime+reserve
+	}
+	return est
+}
@@ -0,0 +1,68 @@
+// smartreturn_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDrainRate(t *testing.T) {
+	start := time.Now()
+	if r := drainRate(nil, 10); r != 10 {
+		t.Errorf("Expected default rate with no samples, got %f", r)
+	}
+	samples := []batterySample{{when: start, pct: 80}, {when: start.Add(5 * time.Second), pct: 79}}
+	if r := drainRate(samples, 10); r != 10 {
+		t.Errorf("Expected default rate with too little history, got %f", r)
+	}
+	samples = []batterySample{{when: start, pct: 80}, {when: start.Add(time.Minute), pct: 74}}
+	if r := drainRate(samples, 10); math.Abs(float64(r-6)) > 0.001 {
+		t.Errorf("Expected 6%%/min, got %f", r)
+	}
+}
+
+func TestEstimateReturn(t *testing.T) {
+	cfg := DefaultSmartReturnConfig()
+	// 30m at 1m/s + 2m at 0.5m/s + 5s landing = 39s, at 10%/min = 6.5% + 10% reserve
+	est := estimateReturn(cfg, 30, 2, 50, 3800, 10, 0)
+	if est.ReturnTime.Round(time.Millisecond) != 39*time.Second {
+		t.Errorf("Expected return time of 39s, got %v", est.ReturnTime)
+	}
+	if math.Abs(float64(est.NeededPct-16.5)) > 0.001 || est.ReturnNow {
+		t.Errorf("Expected 16.5%% needed and no return, got %v", est)
+	}
+	if est = estimateReturn(cfg, 30, 2, 16, 3800, 10, 0); !est.ReturnNow {
+		t.Error("Expected return when battery is below the needed level")
+	}
+	if est = estimateReturn(cfg, 1, 1, 90, 3400, 10, 0); !est.ReturnNow {
+		t.Error("Expected return when battery voltage is low")
+	}
+	// 39s to return plus 10% reserve at 10%/min = 99s
+	if est = estimateReturn(cfg, 30, 2, 50, 3800, 10, 120*time.Second); est.ReturnNow || est.DroneTimeLeft != 120*time.Second {
+		t.Errorf("Expected no return with 120s of flying time left, got %v", est)
+	}
+	if est = estimateReturn(cfg, 30, 2, 50, 3800, 10, 90*time.Second); !est.ReturnNow {
+		t.Error("Expected return when the drone's own time left is too short")
+	}
+}
@@ -0,0 +1,108 @@
+// smartvideo.go
+
+// This file contains the smart video status tracking.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"time"
+)
+
+// svStartTimeout is how long we wait for the drone to report that a smart video action has started.
+const svStartTimeout = 3 * time.Second
+
+// SmartVideoResult reports the end of a smart video action started by StartSmartVideo().
+type SmartVideoResult struct {
+	Cmd      SvCmd
+	ExitMode int16 // as reported by the drone, also stored in FlightData.SmartVideoExitMode
+	Err      error // non-nil if the action did not complete, eg. it did not start or was stopped
+}
+
+// smartVideoState tracks the current smart video action, it is protected by ctrlMu.
+type smartVideoState struct {
+	cmd     SvCmd
+	started bool // has the drone reported the action as running?
+	done    chan SmartVideoResult
+}
+
+// decodeSmartVideoStatus interprets a smart video status payload, which we take to share the layout
+// of the command we send: bit 0 set while running, bits 2-4 the action, then a 16-bit exit mode.
+func decodeSmartVideoStatus(pl []byte) (running bool, cmd SvCmd, exitMode int16) {
+	if len(pl) == 0 {
+		return false, 0, 0
+	}
+	running = pl[0]&0x01 != 0
+	cmd = SvCmd(pl[0] & 0x1c)
+	if len(pl) >= 3 {
+		exitMode = int16(pl[1]) | int16(pl[2])<<8
+	}
+	return running, cmd, exitMode
+}
+
+// IsSmartVideoRunning tests whether a smart video action started by StartSmartVideo() is in progress.
+func (tello *Tello) IsSmartVideoRunning() (running bool) {
+	tello.ctrlMu.RLock()
+	running = tello.smartVideo != nil
+	tello.ctrlMu.RUnlock()
+	return running
+}
+
+// handleSmartVideoStatus is called for every smart video status message from the drone.
+func (tello *Tello) handleSmartVideoStatus(pl []byte) {
+	running, cmd, exitMode := decodeSmartVideoStatus(pl)
+	tello.ctrlMu.Lock()
+	sv := tello.smartVideo
+	switch {
+	case sv == nil:
+	case cmd != sv.cmd: // a report about some other action says nothing about ours
+	case running:
+		sv.started = true
+	case sv.started: // it has finished
+		tello.smartVideo = nil
+		sv.done <- SmartVideoResult{Cmd: sv.cmd, ExitMode: exitMode}
+	}
+	tello.ctrlMu.Unlock()
+	if !running {
+		tello.fdMu.Lock()
+		tello.fd.SmartVideoExitMode = exitMode
+		tello.fdMu.Unlock()
+	}
+}
+
+// endSmartVideo reports the end of any running smart video action with the given error,
+// it must be called with ctrlMu held.
+func (tello *Tello) endSmartVideo(err error) {
+	if sv := tello.smartVideo; sv != nil {
+		tello.smartVideo = nil
+		sv.done <- SmartVideoResult{Cmd: sv.cmd, Err: err}
+	}
+}
+
+// checkSmartVideoStarted fails a smart video action which the drone has not reported as running.
+func (tello *Tello) checkSmartVideoStarted(sv *smartVideoState) {
+	tello.ctrlMu.Lock()
+	if tello.smartVideo == sv && !sv.started {
+		tello.endSmartVideo(errors.New("Smart video action did not start"))
+	}
+	tello.ctrlMu.Unlock()
+}
@@ -0,0 +1,91 @@
+// smartvideo_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeSmartVideoStatus(t *testing.T) {
+	running, cmd, exit := decodeSmartVideoStatus([]byte{byte(SvCircle) | 0x01, 0x00, 0x00})
+	if !running || cmd != SvCircle || exit != 0 {
+		t.Errorf("Expected running circle, got %v %d %d", running, cmd, exit)
+	}
+	running, cmd, exit = decodeSmartVideoStatus([]byte{byte(Sv360), 0x02, 0x00})
+	if running || cmd != Sv360 || exit != 2 {
+		t.Errorf("Expected stopped 360 with exit mode 2, got %v %d %d", running, cmd, exit)
+	}
+	if running, _, _ = decodeSmartVideoStatus(nil); running {
+		t.Error("Expected empty payload to decode as not running")
+	}
+}
+
+func TestSmartVideoCompletion(t *testing.T) {
+	var tello Tello
+	if _, err := tello.StartSmartVideo(Sv360); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+	sv := &smartVideoState{cmd: SvUpOut, done: make(chan SmartVideoResult, 1)}
+	tello.smartVideo = sv
+	tello.handleSmartVideoStatus([]byte{byte(SvUpOut), 0x00, 0x00}) // not yet started, ignored
+	if !tello.IsSmartVideoRunning() {
+		t.Fatal("Expected smart video to be running before it has started")
+	}
+	tello.handleSmartVideoStatus([]byte{byte(SvUpOut) | 0x01, 0x00, 0x00})
+	tello.handleSmartVideoStatus([]byte{byte(SvCircle), 0x02, 0x00}) // a different action, ignored
+	if !tello.IsSmartVideoRunning() {
+		t.Fatal("Expected a report for a different action not to end smart video")
+	}
+	tello.handleSmartVideoStatus([]byte{byte(SvUpOut), 0x01, 0x00})
+	select {
+	case res := <-sv.done:
+		if res.Cmd != SvUpOut || res.ExitMode != 1 || res.Err != nil {
+			t.Errorf("Unexpected result %+v", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected a result")
+	}
+	if tello.IsSmartVideoRunning() || tello.GetFlightData().SmartVideoExitMode != 1 {
+		t.Error("Expected smart video to have finished with exit mode 1")
+	}
+
+	sv = &smartVideoState{cmd: Sv360, done: make(chan SmartVideoResult, 1)}
+	tello.smartVideo = sv
+	tello.checkSmartVideoStarted(sv)
+	if res := <-sv.done; res.Err == nil {
+		t.Error("Expected an error when the action never started")
+	}
+}
+
+func TestSmartVideoEndedByEmergencyStop(t *testing.T) {
+	var tello Tello
+	sv := &smartVideoState{cmd: SvCircle, done: make(chan SmartVideoResult, 1)}
+	tello.smartVideo = sv
+	tello.EmergencyStop()
+	if tello.IsSmartVideoRunning() {
+		t.Error("Expected emergency stop to end smart video")
+	}
+	if res := <-sv.done; res.Err != errEmergencyStopped || res.Cmd != SvCircle {
+		t.Errorf("Unexpected result %+v", res)
+	}
+}
@@ -23,8 +23,10 @@ package tello
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 	"log"
+	"math"
 	"net"
 	"strconv"
 	"strings"
@@ -55,7 +57,11 @@ type Tello struct {
 	ctrlRx, ctrlRy, ctrlLx, ctrlLy int16 // we are using the SDL convention: vals range from -32768 to 32767
 	ctrlSportsMode                 bool  // are we in 'sports' (a.k.a. 'Fast') mode?
 	ctrlBouncing                   bool  // do we think we are bouncing?
+	ctrlLanding                    bool  // have we asked the Tello to land?
+	ctrlPalmLanding                bool  // have we asked the Tello to palm land?
 	videoChan                      chan []byte
+	videoDynamic                   bool              // has dynamic bitrate adjustment been enabled? protected by ctrlMu
+	smartVideo                     *smartVideoState  // nil unless a smart video action is running, protected by ctrlMu
 	stickChan                      chan StickMessage // this will receive stick updates from the user
 	stickListening                 bool              // are we currently listening on stickChan?
 	stickListeningMu               sync.RWMutex
@@ -73,6 +79,46 @@ type Tello struct {
 	homeValid                      bool         // has an home point been set?
 	homeX, homeY                   float32      // set on request to provide a frame of reference
 	homeYaw                        int16        // 0 - 360 degrees, yaw when origin set
+	autoTrajMu                     sync.RWMutex
+	autoTraj                       bool // flag for trajectory following
+	autoTuneMu                     sync.RWMutex
+	autoTune                       bool // flag for auto-tuning
+	gainsMu                        sync.RWMutex
+	gains                          *AutopilotGains // nil means use the defaults
+	autoPathMu                     sync.RWMutex
+	autoPath                       bool // flag for flying a path of waypoints
+	autoFollowMu                   sync.RWMutex
+	autoFollow                     bool // flag for following a target
+	eventsMu                       sync.Mutex
+	eventListeners                 map[chan Event]chan Event
+	fenceMu                        sync.RWMutex // fenceMu protects fence and fenceState
+	fence                          *Geofence    // nil if no geofence is set
+	fenceState                     fenceState
+	failsafeMu                     sync.RWMutex // failsafeMu protects failsafePolicy and failsafe
+	failsafePolicy                 FailsafePolicy
+	failsafe                       failsafeState
+	rxTimes                        map[uint16]time.Time // when each message type was last received, protected by fdMu
+	smartReturnMu                  sync.Mutex
+	smartReturn                    *smartReturnState
+	stickUpdated                   time.Time     // when UpdateSticks was last called, protected by ctrlMu
+	deadmanInterval                time.Duration // zero if the stick deadman is disabled, protected by ctrlMu
+	deadmanTripped                 bool          // has the deadman centred the sticks since the last update?
+	ctrlEmergency                  bool          // has an emergency stop occurred? protected by ctrlMu
+	ctrlEmergencyUntil             time.Time     // when to stop sending the motor-stop sticks
+	preflightMu                    sync.RWMutex
+	preflightRequired              bool
+	preflight                      *PreflightReport // the most recent pre-flight check
+	restrictMu                     sync.RWMutex
+	restrict                       *Restrictions // nil if no restrictions profile is set
+	phaseMu                        sync.RWMutex
+	phase                          FlightPhase
+	phaseChanged                   chan struct{} // closed and replaced whenever the phase changes
+	ctrlTakeoffAt, ctrlThrowAt     time.Time     // when a takeoff or throw takeoff was last requested, protected by ctrlMu
+	mvoPosUpdated                  time.Time     // when a valid MVO position was last received, protected by fdMu
+	ssidPassword                   string        // protected by fdMu
+	loaderVersion                  string        // protected by fdMu
+	activated                      time.Time     // protected by fdMu
+	droneErrors                    []DroneError  // recent error reports, protected by fdMu
 }
 
 // ControlConnect attempts to connect to a Tello at the provided network addr.
@@ -154,6 +200,7 @@ func (tello *Tello) ControlDisconnect() {
 	tello.ctrlMu.Lock()
 	tello.ctrlConn.Close()
 	tello.ctrlConnected = false
+	tello.endSmartVideo(errNotConnected)
 	tello.ctrlMu.Unlock()
 	tello.fdMu.Lock()
 	for l := range tello.filesListeners {
@@ -161,6 +208,7 @@ func (tello *Tello) ControlDisconnect() {
 		close(l)
 	}
 	tello.fdMu.Unlock()
+	tello.closeEventListeners()
 }
 
 // ControlConnected returns true if we are currently connected.
@@ -171,16 +219,55 @@ func (tello *Tello) ControlConnected() (c bool) {
 	return c
 }
 
-// GetAttitude requests the current flight attitude data.
-// always seems to return 5 bytes 00 00 00 c8 41
-// func (tello *Tello) GetAttitude() {
-// 	tello.ctrlMu.Lock()
-// 	defer tello.ctrlMu.Unlock()
+// Limits for SetAttitudeLimit()...
+const (
+	MinAttitudeLimitDeg = 5
+	MaxAttitudeLimitDeg = 45
+)
+
+// attitudeReadbackTimeout is how long we wait for the drone to confirm a new attitude limit.
+const attitudeReadbackTimeout = 2 * time.Second
+
+// GetAttitudeLimit asks the Tello to send its maximum tilt angle, which is stored in
+// FlightData.AttitudeLimit in degrees when it is received.
+// The reply payload is a status byte followed by a float32, eg. 00 00 00 c8 41 => 25 degrees.
+func (tello *Tello) GetAttitudeLimit() error {
+	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+
+	tello.ctrlSeq++
+	pkt := newPacket(ptGet, msgQueryAttitude, tello.ctrlSeq, 0)
+	return tello.writePacket(pkt)
+}
 
-// 	tello.ctrlSeq++
-// 	pkt := newPacket(ptGet, msgQueryAttitude, tello.ctrlSeq, 0)
-// 	tello.ctrlConn.Write(packetToBuffer(pkt))
-// }
+// SetAttitudeLimit sets the Tello's maximum tilt angle in degrees, then reads it back to confirm
+// that it has taken effect.  Lower values make the drone gentler (eg. for beginners), higher
+// values make it more agile.
+func (tello *Tello) SetAttitudeLimit(deg float32) error {
+	if deg < MinAttitudeLimitDeg || deg > MaxAttitudeLimitDeg {
+		return errors.New("Attitude limit must be between 5 and 45 degrees")
+	}
+	tello.ctrlMu.Lock()
+	tello.ctrlSeq++
+	pkt := newPacket(ptSet, msgSetAttitude, tello.ctrlSeq, 4)
+	binary.LittleEndian.PutUint32(pkt.payload, math.Float32bits(deg))
+	err := tello.writePacket(pkt)
+	tello.ctrlMu.Unlock()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	if err = tello.GetAttitudeLimit(); err != nil {
+		return err
+	}
+	if missing := tello.awaitResponses(start, attitudeReadbackTimeout, msgQueryAttitude); len(missing) > 0 {
+		return errors.New("Timeout waiting for attitude limit readback")
+	}
+	if got := tello.GetFlightData().AttitudeLimit; math.Abs(float64(got-deg)) > 0.01 {
+		return errors.New("Attitude limit readback does not match")
+	}
+	return nil
+}
 
 // GetFlightData returns the current known state of the Tello.
 func (tello *Tello) GetFlightData() FlightData {
@@ -190,6 +277,26 @@ func (tello *Tello) GetFlightData() FlightData {
 	return rfd
 }
 
+// awaitResponses waits until each of the given message types has been received since the given time,
+// or until the timeout expires.  It returns the message types which did not arrive.
+func (tello *Tello) awaitResponses(since time.Time, timeout time.Duration, msgIDs ...uint16) (missing []uint16) {
+	deadline := time.Now().Add(timeout)
+	for {
+		missing = missing[:0]
+		tello.fdMu.RLock()
+		for _, id := range msgIDs {
+			if !tello.rxTimes[id].After(since) {
+				missing = append(missing, id)
+			}
+		}
+		tello.fdMu.RUnlock()
+		if len(missing) == 0 || time.Now().After(deadline) {
+			return missing
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
 // GetLowBatteryThreshold requests the threshold from the Tello which is stored in
 // FlightData.LowBatteryThreshold as an integer percentage, i.e. from 0 to 100.
 func (tello *Tello) GetLowBatteryThreshold() {
@@ -211,6 +318,43 @@ func (tello *Tello) GetMaxHeight() {
 	tello.ctrlConn.Write(packetToBuffer(pkt))
 }
 
+// Limits for SetMaxHeight()...
+const (
+	MinMaxHeightM = 2
+	MaxMaxHeightM = 30
+)
+
+// heightReadbackTimeout is how long we wait for the drone to confirm a new height limit.
+const heightReadbackTimeout = 2 * time.Second
+
+// SetMaxHeight sets the Tello's maximum permitted height in metres, then reads it back
+// to confirm that it has taken effect.  The value is stored in FlightData.MaxHeight and
+// AutoFlyToHeight() refuses targets above it.
+func (tello *Tello) SetMaxHeight(m uint8) error {
+	if m < MinMaxHeightM || m > MaxMaxHeightM {
+		return errors.New("Maximum height must be between 2 and 30 metres")
+	}
+	tello.ctrlMu.Lock()
+	tello.ctrlSeq++
+	pkt := newPacket(ptSet, msgSetHeightLimit, tello.ctrlSeq, 2)
+	pkt.payload[0] = m
+	pkt.payload[1] = 0
+	err := tello.writePacket(pkt)
+	tello.ctrlMu.Unlock()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	tello.GetMaxHeight()
+	if missing := tello.awaitResponses(start, heightReadbackTimeout, msgQueryHeightLimit); len(missing) > 0 {
+		return errors.New("Timeout waiting for maximum height readback")
+	}
+	if got := tello.GetFlightData().MaxHeight; got != m {
+		return errors.New("Maximum height readback of " + strconv.Itoa(int(got)) + "m does not match")
+	}
+	return nil
+}
+
 // GetSSID asks the Tello to send us its current Wifi AP ID.
 func (tello *Tello) GetSSID() {
 	tello.ctrlMu.Lock()
@@ -318,11 +462,19 @@ func (tello *Tello) controlResponseListener() {
 				log.Printf("Unexpected network message from Tello <%d>\n", buff[0])
 			} else {
 				pkt := bufferToPacket(buff)
+				tello.fdMu.Lock()
+				if tello.rxTimes == nil {
+					tello.rxTimes = map[uint16]time.Time{}
+				}
+				tello.rxTimes[pkt.messageID] = time.Now()
+				tello.fdMu.Unlock()
 				switch pkt.messageID {
 				case msgDoLand: // ignore for now
 				case msgDoTakeoff: // ignore for now
 				case msgDoTakePic:
 					log.Printf("Take Picture echoed with response: <%v>\n", pkt.payload)
+				case msgError1, msgError2:
+					tello.handleDroneError(pkt)
 				case msgFileSize: // initial response to Take Picture command
 					ft, fs, fID := payloadToFileInfo(pkt.payload)
 					//log.Printf("Take pic response: type: %d, size: %d, ID: %d\n", ft, fs, fID)
@@ -412,6 +564,7 @@ func (tello *Tello) controlResponseListener() {
 					tello.fd.VerticalSpeed = -tmpFd.VerticalSpeed // seems to be inverted
 					tello.fd.WindState = tmpFd.WindState
 					tello.fdMu.Unlock()
+					tello.updatePhase()
 				case msgLightStrength:
 					// Light strength is sent regularly by the drone, seems a good candidate for "still here"-type functionality
 					// log.Printf("Light strength received - Size: %d, Type: %d\n", pkt.size13, pkt.packetType)
@@ -440,6 +593,54 @@ func (tello *Tello) controlResponseListener() {
 					tello.fdMu.Lock()
 					tello.fd.SSID = string(pkt.payload[2:])
 					tello.fdMu.Unlock()
+				case msgQueryAttitude:
+					if len(pkt.payload) >= 5 {
+						tello.fdMu.Lock()
+						tello.fd.AttitudeLimit = bytesToFloat32(pkt.payload[1:5])
+						tello.fdMu.Unlock()
+					}
+				case msgQueryActivationTime:
+					tello.fdMu.Lock()
+					tello.activated = parseActivationTime(pkt.payload)
+					tello.fdMu.Unlock()
+				case msgQueryLoaderVersion:
+					if len(pkt.payload) >= 1 {
+						tello.fdMu.Lock()
+						tello.loaderVersion = strings.TrimRight(string(pkt.payload[1:]), "\x00")
+						tello.fdMu.Unlock()
+					}
+				case msgQueryJPEGQuality:
+					if len(pkt.payload) >= 2 {
+						tello.fdMu.Lock()
+						tello.fd.JPEGQuality = uint8(pkt.payload[1])
+						tello.fdMu.Unlock()
+					}
+				case msgEisSetting, msgExposureVals:
+					// a query reply carries the value after the result byte, a set acknowledgement does not (unverified)
+					if len(pkt.payload) >= 2 {
+						tello.fdMu.Lock()
+						if pkt.messageID == msgEisSetting {
+							tello.fd.EIS = pkt.payload[1] != 0
+						} else {
+							tello.fd.Exposure = ExposureLevel(pkt.payload[1])
+						}
+						tello.fdMu.Unlock()
+					}
+				case msgQuerySSIDPass:
+					if len(pkt.payload) >= 2 {
+						tello.fdMu.Lock()
+						tello.ssidPassword = string(pkt.payload[2:])
+						tello.fdMu.Unlock()
+					}
+				case msgQueryWifiRegion:
+					if len(pkt.payload) >= 1 {
+						tello.fdMu.Lock()
+						tello.fd.WifiRegion = WifiRegion(strings.TrimRight(string(pkt.payload[1:]), "\x00"))
+						tello.fdMu.Unlock()
+					}
+				case msgSetSSID, msgSetSSIDPass, msgSetWifiRegion, msgSetHeightLimit, msgSetAttitude: // ignore, we confirm by reading back
+				case msgDoCalibration: // ignore, progress is tracked via ImuCalibrationState
+				case msgSetDynAdjRate: // ignore, the setting cannot be queried
 				case msgQueryVersion:
 					//log.Printf("Version recieved: % x\n", pkt.payload)
 					tello.fdMu.Lock()
@@ -455,7 +656,8 @@ func (tello *Tello) controlResponseListener() {
 					//log.Println("DateTime request received from Tello")
 					tello.sendDateTime()
 				case msgSetLowBattThresh: // ignore for now (could be error return)
-				case msgSmartVideoStatus: // ignore
+				case msgSmartVideoStatus:
+					tello.handleSmartVideoStatus(pkt.payload)
 				case msgSwitchPicVideo: // ignore
 				case msgWifiStrength:
 					// log.Printf("Wifi strength received - Size: %d, Type: %d\n", pkt.size13, pkt.packetType)
@@ -530,6 +732,10 @@ func (tello *Tello) keepAlive() {
 	var sinceLastLSupdate time.Duration
 	for {
 		if tello.ControlConnected() {
+			tello.checkFailsafe()
+			tello.checkSmartReturn()
+			tello.checkDeadman()
+			tello.checkGeofence()
 			tello.sendStickUpdate()
 			tello.fdMu.RLock()
 			if tello.fd.LightStrengthUpdated.IsZero() {
@@ -546,6 +752,7 @@ func (tello *Tello) keepAlive() {
 				log.Printf("Last update was %v ago", sinceLastLSupdate)
 				tello.ctrlMu.Lock()
 				tello.ctrlConnected = false
+				tello.endSmartVideo(errors.New("Lost contact with the drone"))
 				tello.ctrlMu.Unlock()
 				return // disconnected - so stop this Goroutine
 			}
@@ -600,12 +807,19 @@ func (tello *Tello) StopStickListener() {
 
 // UpdateSticks does a one-off update of the stick values which are then sent to the Tello.
 // N.B. All four axes are updated on every call to this func.
+// Updates are ignored after an emergency stop until Rearm() is called.
 func (tello *Tello) UpdateSticks(sm StickMessage) {
 	tello.ctrlMu.Lock()
+	if tello.ctrlEmergency {
+		tello.ctrlMu.Unlock()
+		return
+	}
 	tello.ctrlLx = sm.Lx
 	tello.ctrlLy = sm.Ly
 	tello.ctrlRx = sm.Rx
 	tello.ctrlRy = sm.Ry
+	tello.stickUpdated = time.Now()
+	tello.deadmanTripped = false
 	tello.ctrlMu.Unlock()
 }
 
@@ -634,11 +848,18 @@ func (tello *Tello) sendStickUpdate() {
 	pkt.sequence = 0
 	pkt.payload = make([]byte, 11)
 
+	// apply any restrictions profile and geofence
+	rx, ry, lx, ly := tello.restrictSticks(tello.ctrlRx, tello.ctrlRy, tello.ctrlLx, tello.ctrlLy)
+	rx, ry, lx, ly = tello.fenceSticks(rx, ry, lx, ly)
+	if tello.ctrlEmergency {
+		rx, ry, lx, ly = tello.emergencySticks()
+	}
+
 	// This packing of the joystick data is just vile...
-	packedAxes := jsInt16ToTello(tello.ctrlRx) & 0x07ff
-	packedAxes |= (jsInt16ToTello(tello.ctrlRy) & 0x07ff) << 11
-	packedAxes |= (jsInt16ToTello(tello.ctrlLy) & 0x07ff) << 22
-	packedAxes |= (jsInt16ToTello(tello.ctrlLx) & 0x07ff) << 33
+	packedAxes := jsInt16ToTello(rx) & 0x07ff
+	packedAxes |= (jsInt16ToTello(ry) & 0x07ff) << 11
+	packedAxes |= (jsInt16ToTello(ly) & 0x07ff) << 22
+	packedAxes |= (jsInt16ToTello(lx) & 0x07ff) << 33
 	if tello.ctrlSportsMode {
 		packedAxes |= 1 << 44
 	}
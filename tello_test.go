@@ -161,3 +161,41 @@ func TestGetSSID(t *testing.T) {
 	drone.ControlDisconnect()
 	log.Println("Disconnected normally from Tello")
 }
+
+func TestSetMaxHeightRange(t *testing.T) {
+	var drone Tello
+	if err := drone.SetMaxHeight(1); err == nil {
+		t.Error("Expected 1m height limit to be rejected")
+	}
+	if err := drone.SetMaxHeight(31); err == nil {
+		t.Error("Expected 31m height limit to be rejected")
+	}
+}
+
+func TestMaxHeightCmds(t *testing.T) {
+	drone := new(Tello)
+	err := drone.ControlConnectDefault()
+	if err != nil {
+		log.Fatalf("CCD failed with error %v", err)
+	}
+	log.Println("Connected to Tello control channel")
+
+	if err = drone.SetMaxHeight(5); err != nil {
+		t.Errorf("SetMaxHeight failed with error %v", err)
+	}
+	log.Printf("Max height now: %d\n", drone.GetFlightData().MaxHeight)
+	drone.ControlDisconnect()
+}
+
+func TestAttitudeLimit(t *testing.T) {
+	var drone Tello
+	if err := drone.SetAttitudeLimit(60); err == nil {
+		t.Error("Expected 60 degree attitude limit to be rejected")
+	}
+	if err := drone.SetAttitudeLimit(20); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+	if lim := bytesToFloat32([]byte{0x00, 0x00, 0xc8, 0x41}); lim != 25 {
+		t.Errorf("Expected observed payload to decode as 25 degrees, got %f", lim)
+	}
+}
@@ -0,0 +1,389 @@
+// trajectory.go
+
+// This file contains smooth trajectory generation and tracking for the autopilot.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	trajSamplesPerSegment = 50 // resolution of the arc-length table for each spline segment
+	// stickFullSpeedMS is the approximate horizontal speed in m/s at full stick deflection (normal mode).
+	stickFullSpeedMS = 3.0
+	// stickFullClimbMS is the approximate vertical speed in m/s at full stick deflection.
+	stickFullClimbMS = 1.0
+	// AutoTrajPosGain is the default proportional gain (per second) applied to position errors while tracking a trajectory.
+	AutoTrajPosGain = 1.2
+	// AutoTrajSettleTimeout is how long we allow for the drone to reach the final point after the trajectory ends.
+	AutoTrajSettleTimeout = 5 * time.Second
+)
+
+// Point3D is a position in the home frame.
+// X and Y are in metres from the home point, Z is the height in metres.
+type Point3D struct {
+	X, Y, Z float32
+}
+
+// Trajectory is a smooth, time-parameterised path through a series of waypoints.
+// Create one with NewTrajectory() and fly it with AutoFlyTrajectory().
+type Trajectory struct {
+	points []Point3D    // dense samples along the curve
+	dists  []float64    // cumulative arc length at each sample
+	speeds []float64    // speed profile at each sample in m/s
+	times  []float64    // cumulative time at each sample in seconds
+	tangts [][3]float64 // unit tangent at each sample
+}
+
+// NewTrajectory fits a Catmull-Rom spline through the given waypoints and time-parameterises
+// it so that the speed never exceeds maxSpeed (m/s) and neither the along-track nor the
+// cornering acceleration exceeds maxAccel (m/s/s).  The trajectory starts and ends at rest.
+func NewTrajectory(waypoints []Point3D, maxSpeed, maxAccel float32) (*Trajectory, error) {
+	return newTrajectory(waypoints, maxSpeed, maxAccel, false)
+}
+
+// NewStraightTrajectory is like NewTrajectory() but joins the waypoints with straight lines,
+// slowing almost to a stop at each corner, so that the drone keeps to the legs between them.
+func NewStraightTrajectory(waypoints []Point3D, maxSpeed, maxAccel float32) (*Trajectory, error) {
+	return newTrajectory(waypoints, maxSpeed, maxAccel, true)
+}
+
+func newTrajectory(waypoints []Point3D, maxSpeed, maxAccel float32, straight bool) (*Trajectory, error) {
+	if len(waypoints) < 2 {
+		return nil, errors.New("A trajectory needs at least two waypoints")
+	}
+	if maxSpeed <= 0 || maxSpeed > stickFullSpeedMS {
+		return nil, errors.New("Trajectory speed limit out of range")
+	}
+	if maxAccel <= 0 {
+		return nil, errors.New("Trajectory acceleration limit must be positive")
+	}
+	for _, wp := range waypoints {
+		if wp.X > AutoXYLimitM || wp.Y > AutoXYLimitM || wp.X < -AutoXYLimitM || wp.Y < -AutoXYLimitM {
+			return nil, errors.New("Horizontal navigation limit exceeded")
+		}
+		if wp.Z*10 > AutoHeightLimitDm || wp.Z < 0 {
+			return nil, errors.New("Verical navigation limit exceeded")
+		}
+	}
+
+	traj := &Trajectory{}
+	if straight {
+		traj.sampleLines(waypoints)
+	} else {
+		traj.sampleSpline(waypoints)
+	}
+	if traj.dists[len(traj.dists)-1] == 0 {
+		return nil, errors.New("Trajectory waypoints are all identical")
+	}
+	traj.planSpeeds(float64(maxSpeed), float64(maxAccel))
+	return traj, nil
+}
+
+// Duration returns the time it should take to fly the trajectory.
+func (traj *Trajectory) Duration() time.Duration {
+	return time.Duration(traj.times[len(traj.times)-1] * float64(time.Second))
+}
+
+// Length returns the length of the trajectory in metres.
+func (traj *Trajectory) Length() float32 {
+	return float32(traj.dists[len(traj.dists)-1])
+}
+
+// Sample returns the planned position and velocity (m/s) at time t after the trajectory starts.
+// Times beyond the end of the trajectory return the final point at rest.
+func (traj *Trajectory) Sample(t time.Duration) (pos, vel Point3D) {
+	secs := t.Seconds()
+	last := len(traj.times) - 1
+	if secs <= 0 {
+		return traj.points[0], Point3D{}
+	}
+	if secs >= traj.times[last] {
+		return traj.points[last], Point3D{}
+	}
+	i := sort.SearchFloat64s(traj.times, secs) // times[i-1] < secs <= times[i]
+	frac := (secs - traj.times[i-1]) / (traj.times[i] - traj.times[i-1])
+	pos = lerpPoint(traj.points[i-1], traj.points[i], frac)
+	speed := traj.speeds[i-1] + (traj.speeds[i]-traj.speeds[i-1])*frac
+	tan := traj.tangts[i]
+	vel = Point3D{X: float32(speed * tan[0]), Y: float32(speed * tan[1]), Z: float32(speed * tan[2])}
+	return pos, vel
+}
+
+// sampleSpline densely samples a uniform Catmull-Rom spline through the waypoints,
+// building the arc-length table.  The end points are duplicated so that the curve
+// passes through every waypoint.
+func (traj *Trajectory) sampleSpline(wps []Point3D) {
+	ctrl := make([]Point3D, 0, len(wps)+2)
+	ctrl = append(ctrl, wps[0])
+	ctrl = append(ctrl, wps...)
+	ctrl = append(ctrl, wps[len(wps)-1])
+
+	traj.points = append(traj.points, wps[0])
+	for seg := 1; seg < len(ctrl)-2; seg++ {
+		for s := 1; s <= trajSamplesPerSegment; s++ {
+			u := float64(s) / trajSamplesPerSegment
+			traj.points = append(traj.points, catmullRom(ctrl[seg-1], ctrl[seg], ctrl[seg+1], ctrl[seg+2], u))
+		}
+	}
+	traj.buildArcTable()
+}
+
+// sampleLines densely samples straight lines between the waypoints, building the arc-length table.
+func (traj *Trajectory) sampleLines(wps []Point3D) {
+	traj.points = append(traj.points, wps[0])
+	for seg := 1; seg < len(wps); seg++ {
+		for s := 1; s <= trajSamplesPerSegment; s++ {
+			traj.points = append(traj.points, lerpPoint(wps[seg-1], wps[seg], float64(s)/trajSamplesPerSegment))
+		}
+	}
+	traj.buildArcTable()
+}
+
+// buildArcTable calculates the cumulative distance and unit tangent at each sample.
+func (traj *Trajectory) buildArcTable() {
+	traj.dists = make([]float64, len(traj.points))
+	traj.tangts = make([][3]float64, len(traj.points))
+	for i := 1; i < len(traj.points); i++ {
+		d := pointDelta(traj.points[i-1], traj.points[i])
+		l := vecLen(d)
+		traj.dists[i] = traj.dists[i-1] + l
+		if l > 0 {
+			traj.tangts[i] = [3]float64{d[0] / l, d[1] / l, d[2] / l}
+		} else {
+			traj.tangts[i] = traj.tangts[i-1]
+		}
+	}
+	traj.tangts[0] = traj.tangts[1]
+}
+
+// planSpeeds builds the speed profile and timing.  Each sample is first limited by maxSpeed and by
+// the cornering acceleration at that point, then forward and backward passes limit the
+// along-track acceleration.
+func (traj *Trajectory) planSpeeds(maxSpeed, maxAccel float64) {
+	n := len(traj.points)
+	traj.speeds = make([]float64, n)
+	for i := 1; i < n-1; i++ {
+		traj.speeds[i] = maxSpeed
+		if k := curvature(traj.points[i-1], traj.points[i], traj.points[i+1]); k > 0 {
+			traj.speeds[i] = math.Min(maxSpeed, math.Sqrt(maxAccel/k))
+		}
+	}
+	// start and end at rest
+	traj.speeds[0] = 0
+	traj.speeds[n-1] = 0
+	for i := 1; i < n; i++ {
+		ds := traj.dists[i] - traj.dists[i-1]
+		traj.speeds[i] = math.Min(traj.speeds[i], math.Sqrt(traj.speeds[i-1]*traj.speeds[i-1]+2*maxAccel*ds))
+	}
+	for i := n - 2; i >= 0; i-- {
+		ds := traj.dists[i+1] - traj.dists[i]
+		traj.speeds[i] = math.Min(traj.speeds[i], math.Sqrt(traj.speeds[i+1]*traj.speeds[i+1]+2*maxAccel*ds))
+	}
+
+	traj.times = make([]float64, n)
+	for i := 1; i < n; i++ {
+		ds := traj.dists[i] - traj.dists[i-1]
+		dt := 0.0
+		if vAvg := (traj.speeds[i-1] + traj.speeds[i]) / 2; ds > 0 && vAvg > 0 {
+			dt = ds / vAvg
+		}
+		traj.times[i] = traj.times[i-1] + dt
+	}
+}
+
+// CancelAutoFlyTrajectory stops any in-flight AutoFlyTrajectory navigation.
+// The drone should stop.
+func (tello *Tello) CancelAutoFlyTrajectory() {
+	tello.autoTrajMu.Lock()
+	tello.autoTraj = false
+	tello.autoTrajMu.Unlock()
+}
+
+// IsAutoTrajectory tests whether we are currently following a trajectory.
+func (tello *Tello) IsAutoTrajectory() (set bool) {
+	tello.autoTrajMu.RLock()
+	set = tello.autoTraj
+	tello.autoTrajMu.RUnlock()
+	return set
+}
+
+// AutoFlyTrajectory starts following the given trajectory, which is expressed in the
+// home frame (so the home point must have been previously set).
+// The planned velocity is fed forward to the sticks and corrected by the position error
+// measured from the MVO data and the height.
+// The func returns immediately and a Goroutine handles the navigation until either
+// it is complete or cancelled via CancelAutoFlyTrajectory().
+// The caller may optionally listen on the 'done' channel for a signal that
+// the navigation is complete (or has been cancelled).
+func (tello *Tello) AutoFlyTrajectory(traj *Trajectory) (done chan bool, err error) {
+	if err = tello.checkArmed(); err != nil {
+		return nil, err
+	}
+	if traj == nil {
+		return nil, errors.New("No trajectory supplied")
+	}
+	if tello.IsAutoTrajectory() {
+		return nil, errors.New("Already following a trajectory")
+	}
+	if tello.IsAutoXY() || tello.isAutoHeight() || tello.IsAutoFollowing() || tello.IsAutoTurning() || tello.IsAutoTuning() {
+		return nil, errors.New("Cannot follow a trajectory during other automatic flight")
+	}
+	if !tello.IsHomeSet() {
+		return nil, errors.New("Cannot AutoFly as home point has not be set (or is invalid)")
+	}
+	for _, p := range traj.points {
+		if !tello.fenceAllowsXY(p.X, p.Y) || !tello.fenceAllowsHeight(p.Z) {
+			return nil, errors.New("Trajectory passes outside the geofence")
+		}
+	}
+
+	tello.autoTrajMu.Lock()
+	tello.autoTraj = true
+	tello.autoTrajMu.Unlock()
+
+	done = make(chan bool, 1) // buffered so send doesn't block
+
+	go func() {
+		start := time.Now()
+		final, _ := traj.Sample(traj.Duration())
+		for {
+			// has autoflight been cancelled?
+			if !tello.IsAutoTrajectory() {
+				// stop all translational movement
+				tello.ctrlMu.Lock()
+				tello.ctrlRx = 0
+				tello.ctrlRy = 0
+				tello.ctrlLy = 0
+				tello.ctrlMu.Unlock()
+				tello.sendStickUpdate()
+				done <- true
+				return
+			}
+
+			curX, curY, curZ, curYaw := tello.homeRelativePos()
+			tello.fdMu.RLock()
+			lowLight := tello.fd.LightStrength == 1
+			tello.fdMu.RUnlock()
+			if lowLight { // cancel autoflight
+				log.Println("Cancelling trajectory flight due to low light")
+				tello.CancelAutoFlyTrajectory()
+				continue
+			}
+
+			gains := tello.GetAutopilotGains()
+			elapsed := time.Since(start)
+			if elapsed > traj.Duration() {
+				dx, dy := final.X-curX, final.Y-curY
+				arrived := dx*dx+dy*dy <= gains.XYToleranceM*gains.XYToleranceM &&
+					math.Abs(float64(final.Z-curZ)) <= 0.1
+				if arrived || elapsed > traj.Duration()+AutoTrajSettleTimeout {
+					tello.CancelAutoFlyTrajectory()
+					continue
+				}
+			}
+
+			target, vel := traj.Sample(elapsed)
+			vx := vel.X + gains.TrajXYGain*(target.X-curX)
+			vy := vel.Y + gains.TrajXYGain*(target.Y-curY)
+			vz := vel.Z + gains.TrajHeightGain*(target.Z-curZ)
+			// rotate the demanded velocity into the drone's frame
+			bodyX, bodyY := calcXYdeltas(curYaw, 0, 0, vx, vy)
+
+			tello.ctrlMu.Lock()
+			tello.ctrlRx = speedToStick(bodyX, stickFullSpeedMS)
+			tello.ctrlRy = speedToStick(bodyY, stickFullSpeedMS)
+			tello.ctrlLy = speedToStick(vz, stickFullClimbMS)
+			tello.ctrlMu.Unlock()
+
+			time.Sleep(autopilotPeriodMs * time.Millisecond)
+		}
+	}()
+
+	return done, nil
+}
+
+// homeRelativePos returns the current position in the home frame and the current yaw.
+func (tello *Tello) homeRelativePos() (x, y, z float32, yaw int16) {
+	tello.autoXYMu.RLock()
+	tello.fdMu.RLock()
+	x = tello.fd.MVO.PositionX - tello.homeX
+	y = tello.fd.MVO.PositionY - tello.homeY
+	z = float32(tello.fd.Height) / 10
+	yaw = tello.fd.IMU.Yaw
+	tello.fdMu.RUnlock()
+	tello.autoXYMu.RUnlock()
+	return x, y, z, yaw
+}
+
+// speedToStick converts a speed into a stick value given the speed at full deflection.
+func speedToStick(speed, fullSpeed float32) int16 {
+	s := speed / fullSpeed * 32767
+	switch {
+	case s > 32767:
+		return 32767
+	case s < -32767:
+		return -32767
+	}
+	return int16(s)
+}
+
+func catmullRom(p0, p1, p2, p3 Point3D, u float64) Point3D {
+	cr := func(a, b, c, d float32) float32 {
+		fa, fb, fc, fd := float64(a), float64(b), float64(c), float64(d)
+		return float32(0.5 * (2*fb + (-fa+fc)*u + (2*fa-5*fb+4*fc-fd)*u*u + (-fa+3*fb-3*fc+fd)*u*u*u))
+	}
+	return Point3D{X: cr(p0.X, p1.X, p2.X, p3.X), Y: cr(p0.Y, p1.Y, p2.Y, p3.Y), Z: cr(p0.Z, p1.Z, p2.Z, p3.Z)}
+}
+
+// curvature returns the Menger curvature (1/radius) of the circle through three points.
+func curvature(a, b, c Point3D) float64 {
+	ab, bc, ac := pointDelta(a, b), pointDelta(b, c), pointDelta(a, c)
+	cross := [3]float64{
+		ab[1]*bc[2] - ab[2]*bc[1],
+		ab[2]*bc[0] - ab[0]*bc[2],
+		ab[0]*bc[1] - ab[1]*bc[0],
+	}
+	denom := vecLen(ab) * vecLen(bc) * vecLen(ac)
+	if denom == 0 {
+		return 0
+	}
+	return 2 * vecLen(cross) / denom
+}
+
+func pointDelta(a, b Point3D) [3]float64 {
+	return [3]float64{float64(b.X - a.X), float64(b.Y - a.Y), float64(b.Z - a.Z)}
+}
+
+func vecLen(v [3]float64) float64 {
+	return math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+}
+
+func lerpPoint(a, b Point3D, frac float64) Point3D {
+	f := float32(frac)
+	return Point3D{X: a.X + (b.X-a.X)*f, Y: a.Y + (b.Y-a.Y)*f, Z: a.Z + (b.Z-a.Z)*f}
+}
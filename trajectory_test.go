@@ -0,0 +1,106 @@
+// trajectory_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"log"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestNewTrajectory(t *testing.T) {
+	if _, err := NewTrajectory([]Point3D{{0, 0, 1}}, 1, 1); err == nil {
+		t.Error("Expected error for single waypoint")
+	}
+	if _, err := NewTrajectory([]Point3D{{0, 0, 1}, {1, 0, 1}}, 10, 1); err == nil {
+		t.Error("Expected error for excessive speed")
+	}
+
+	wps := []Point3D{{0, 0, 1}, {2, 0, 1}, {2, 2, 1.5}, {0, 2, 1}}
+	traj, err := NewTrajectory(wps, 1.0, 0.5)
+	if err != nil {
+		t.Fatalf("NewTrajectory failed with error %v", err)
+	}
+	log.Printf("Trajectory length: %.2fm, duration: %v\n", traj.Length(), traj.Duration())
+	if traj.Length() < 6 {
+		t.Errorf("Expected length of at least 6m, got %f", traj.Length())
+	}
+
+	// must start and end at the first and last waypoints, at rest
+	p, v := traj.Sample(0)
+	if p != wps[0] || v != (Point3D{}) {
+		t.Errorf("Unexpected start %v, %v", p, v)
+	}
+	p, v = traj.Sample(traj.Duration() + time.Second)
+	if p != wps[len(wps)-1] || v != (Point3D{}) {
+		t.Errorf("Unexpected end %v, %v", p, v)
+	}
+
+	// must pass through the intermediate waypoints
+	for _, wp := range wps[1:3] {
+		nearest := math.MaxFloat64
+		for _, sp := range traj.points {
+			nearest = math.Min(nearest, vecLen(pointDelta(wp, sp)))
+		}
+		if nearest > 0.001 {
+			t.Errorf("Trajectory misses waypoint %v by %f", wp, nearest)
+		}
+	}
+
+	// speed limit must be respected throughout
+	for ts := time.Duration(0); ts < traj.Duration(); ts += 50 * time.Millisecond {
+		_, v = traj.Sample(ts)
+		if s := vecLen([3]float64{float64(v.X), float64(v.Y), float64(v.Z)}); s > 1.0001 {
+			t.Errorf("Speed %f exceeds limit at %v", s, ts)
+		}
+	}
+}
+
+func TestSpeedToStick(t *testing.T) {
+	if s := speedToStick(0, stickFullSpeedMS); s != 0 {
+		t.Errorf("Expected 0, got %d", s)
+	}
+	if s := speedToStick(stickFullSpeedMS*2, stickFullSpeedMS); s != 32767 {
+		t.Errorf("Expected 32767, got %d", s)
+	}
+	if s := speedToStick(-stickFullSpeedMS*2, stickFullSpeedMS); s != -32767 {
+		t.Errorf("Expected -32767, got %d", s)
+	}
+}
+
+func TestAutoFlyTrajectoryBusy(t *testing.T) {
+	var tello Tello
+	traj, err := NewTrajectory([]Point3D{{0, 0, 1}, {1, 1, 1}}, 1, 1)
+	if err != nil {
+		t.Fatalf("NewTrajectory failed with error %v", err)
+	}
+	tello.autoYaw = true
+	if _, err = tello.AutoFlyTrajectory(traj); err == nil {
+		t.Error("Expected trajectory to be refused while turning")
+	}
+	tello.autoYaw = false
+	tello.autoTune = true
+	if _, err = tello.AutoFlyTrajectory(traj); err == nil {
+		t.Error("Expected trajectory to be refused while auto-tuning")
+	}
+}
@@ -101,14 +101,53 @@ func (tello *Tello) GetVideoBitrate() {
 }
 
 // SetVideoBitrate ask the Tello to use the specified bitrate (or auto) for video encoding.
-func (tello *Tello) SetVideoBitrate(vbr VBR) {
+// Requesting a fixed rate also turns off dynamic rate adjustment (see SetDynamicBitrate()),
+// which would otherwise vary the rate from the one requested here; VbrAuto leaves it unchanged.
+func (tello *Tello) SetVideoBitrate(vbr VBR) error {
 	tello.ctrlMu.Lock()
 	defer tello.ctrlMu.Unlock()
 
+	if vbr != VbrAuto {
+		if err := tello.sendDynamicBitrate(false); err != nil {
+			return err
+		}
+	}
 	tello.ctrlSeq++
 	pkt := newPacket(ptSet, msgSetVideoBitrate, tello.ctrlSeq, 1)
 	pkt.payload[0] = byte(vbr)
-	tello.ctrlConn.Write(packetToBuffer(pkt))
+	return tello.writePacket(pkt)
+}
+
+// SetDynamicBitrate enables or disables the Tello's own adaptation of the video bitrate
+// to the quality of the Wifi connection.  SetVideoBitrate() with a fixed rate disables it.
+func (tello *Tello) SetDynamicBitrate(on bool) error {
+	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+	return tello.sendDynamicBitrate(on)
+}
+
+// sendDynamicBitrate sends the dynamic bitrate setting, the caller must hold ctrlMu.
+func (tello *Tello) sendDynamicBitrate(on bool) error {
+	tello.ctrlSeq++
+	pkt := newPacket(ptSet, msgSetDynAdjRate, tello.ctrlSeq, 1)
+	if on {
+		pkt.payload[0] = 1
+	}
+	if err := tello.writePacket(pkt); err != nil {
+		return err
+	}
+	tello.videoDynamic = on
+	return nil
+}
+
+// IsDynamicBitrate tests whether we have enabled the Tello's dynamic bitrate adaptation.
+// The Tello provides no known means of querying this, so it reflects the last call to
+// SetDynamicBitrate() or SetVideoBitrate() and not any setting made by another application.
+func (tello *Tello) IsDynamicBitrate() (on bool) {
+	tello.ctrlMu.RLock()
+	on = tello.videoDynamic
+	tello.ctrlMu.RUnlock()
+	return on
 }
 
 // GetVideoSpsPps asks the Tello to send SPS and PPS in video stream.
@@ -72,3 +72,24 @@ func TestVideoBitrate(t *testing.T) {
 	drone.ControlDisconnect()
 	log.Println("Disconnected normally from Tello")
 }
+
+func TestSetDynamicBitrateNotConnected(t *testing.T) {
+	var drone Tello
+	if err := drone.SetDynamicBitrate(true); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+	if drone.IsDynamicBitrate() {
+		t.Error("Expected dynamic bitrate state to be unchanged after failure")
+	}
+}
+
+func TestSetVideoBitrateNotConnected(t *testing.T) {
+	var drone Tello
+	drone.videoDynamic = true
+	if err := drone.SetVideoBitrate(VbrAuto); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+	if !drone.IsDynamicBitrate() {
+		t.Error("Expected VbrAuto not to change dynamic bitrate state")
+	}
+}
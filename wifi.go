@@ -0,0 +1,189 @@
+// wifi.go
+
+// This file contains the Wifi access point and region management commands.
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// wifiReadbackTimeout is how long we wait for the drone to confirm a Wifi setting.
+const wifiReadbackTimeout = 2 * time.Second
+
+// Wifi limits...
+const (
+	MaxSSIDLen         = 32 // the 802.11 limit
+	MinSSIDPasswordLen = 8  // WPA2 limits
+	MaxSSIDPasswordLen = 63
+)
+
+// ssidChars are those we permit in an SSID, chosen to be safe for both the drone and most OSes.
+const ssidChars = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// validateSSID checks an SSID's length and characters.
+func validateSSID(ssid string) error {
+	if len(ssid) == 0 || len(ssid) > MaxSSIDLen {
+		return errors.New("SSID must be between 1 and 32 characters")
+	}
+	for _, c := range ssid {
+		if !strings.ContainsRune(ssidChars, c) {
+			return errors.New("SSID may only contain letters, digits, '-' and '_'")
+		}
+	}
+	return nil
+}
+
+// validateSSIDPassword checks a password's length and characters.
+func validateSSIDPassword(pass string) error {
+	if len(pass) < MinSSIDPasswordLen || len(pass) > MaxSSIDPasswordLen {
+		return errors.New("Wifi password must be between 8 and 63 characters")
+	}
+	for _, c := range pass {
+		if c < ' ' || c > '~' {
+			return errors.New("Wifi password may only contain printable ASCII characters")
+		}
+	}
+	return nil
+}
+
+// GetSSIDPassword asks the Tello to send us its current Wifi password, which is returned
+// by SSIDPassword() once received.  The password is not stored in FlightData.
+func (tello *Tello) GetSSIDPassword() error {
+	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+
+	tello.ctrlSeq++
+	pkt := newPacket(ptGet, msgQuerySSIDPass, tello.ctrlSeq, 0)
+	return tello.writePacket(pkt)
+}
+
+// SSIDPassword returns the Wifi password most recently received from the Tello.
+func (tello *Tello) SSIDPassword() (pass string) {
+	tello.fdMu.RLock()
+	pass = tello.ssidPassword
+	tello.fdMu.RUnlock()
+	return pass
+}
+
+// SetSSID changes the name of the Tello's Wifi access point, then reads it back to confirm the change.
+// The SSID may contain only letters, digits, '-' and '_'; the official app expects it to begin "TELLO-".
+// N.B. The new SSID is only used after the Tello has been power-cycled, so the current connection
+// is unaffected, but you must reconnect to the new network afterwards.
+func (tello *Tello) SetSSID(ssid string) error {
+	if err := validateSSID(ssid); err != nil {
+		return err
+	}
+	if err := tello.sendWifiSetting(msgSetSSID, ssid); err != nil {
+		return err
+	}
+	start := time.Now()
+	tello.GetSSID()
+	if missing := tello.awaitResponses(start, wifiReadbackTimeout, msgQuerySSID); len(missing) > 0 {
+		return errors.New("Timeout waiting for SSID readback")
+	}
+	if got := strings.TrimRight(tello.GetFlightData().SSID, "\x00"); got != ssid {
+		return errors.New("SSID readback '" + got + "' does not match")
+	}
+	return nil
+}
+
+// SetSSIDPassword changes the password of the Tello's Wifi access point, then reads it back to confirm the change.
+// The password must be 8 to 63 printable ASCII characters.
+// N.B. The new password is only used after the Tello has been power-cycled.
+func (tello *Tello) SetSSIDPassword(pass string) error {
+	if err := validateSSIDPassword(pass); err != nil {
+		return err
+	}
+	if err := tello.sendWifiSetting(msgSetSSIDPass, pass); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := tello.GetSSIDPassword(); err != nil {
+		return err
+	}
+	if missing := tello.awaitResponses(start, wifiReadbackTimeout, msgQuerySSIDPass); len(missing) > 0 {
+		return errors.New("Timeout waiting for Wifi password readback")
+	}
+	if strings.TrimRight(tello.SSIDPassword(), "\x00") != pass {
+		return errors.New("Wifi password readback does not match")
+	}
+	return nil
+}
+
+// validateWifiRegion checks that a region looks like a two-letter country code.
+func validateWifiRegion(region WifiRegion) error {
+	if len(region) != 2 || region[0] < 'A' || region[0] > 'Z' || region[1] < 'A' || region[1] > 'Z' {
+		return errors.New("Wifi region must be a two-letter upper-case country code")
+	}
+	return nil
+}
+
+// GetWifiRegion asks the Tello to send us its Wifi regulatory region, which is stored in
+// FlightData.WifiRegion when it is received.
+func (tello *Tello) GetWifiRegion() error {
+	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+
+	tello.ctrlSeq++
+	pkt := newPacket(ptGet, msgQueryWifiRegion, tello.ctrlSeq, 0)
+	return tello.writePacket(pkt)
+}
+
+// SetWifiRegion sets the Wifi regulatory region, which determines the channels the Tello may use,
+// then reads it back to confirm the change.
+// N.B. Like the SSID, the new region is only used after the Tello has been power-cycled.
+func (tello *Tello) SetWifiRegion(region WifiRegion) error {
+	if err := validateWifiRegion(region); err != nil {
+		return err
+	}
+	if err := tello.sendWifiSetting(msgSetWifiRegion, string(region)); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := tello.GetWifiRegion(); err != nil {
+		return err
+	}
+	if missing := tello.awaitResponses(start, wifiReadbackTimeout, msgQueryWifiRegion); len(missing) > 0 {
+		return errors.New("Timeout waiting for Wifi region readback")
+	}
+	if got := tello.GetFlightData().WifiRegion; got != region {
+		return errors.New("Wifi region readback '" + string(got) + "' does not match")
+	}
+	return nil
+}
+
+// sendWifiSetting sends a string setting to the Tello, refusing to do so while flying.
+func (tello *Tello) sendWifiSetting(msgID uint16, val string) error {
+	tello.ctrlMu.Lock()
+	defer tello.ctrlMu.Unlock()
+	if err := tello.checkFlightPreconditions(false); err != nil {
+		return err
+	}
+
+	tello.ctrlSeq++
+	pkt := newPacket(ptSet, msgID, tello.ctrlSeq, len(val))
+	copy(pkt.payload, val)
+	return tello.writePacket(pkt)
+}
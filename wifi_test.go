@@ -0,0 +1,68 @@
+// wifi_test.go
+
+// Copyright (C) 2018  Steve Merrony
+
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package tello
+
+import (
+	"testing"
+)
+
+func TestValidateSSID(t *testing.T) {
+	for _, ssid := range []string{"TELLO-ABC123", "TELLO_fleet_07", "x"} {
+		if err := validateSSID(ssid); err != nil {
+			t.Errorf("Expected '%s' to be valid, got %v", ssid, err)
+		}
+	}
+	for _, ssid := range []string{"", "TELLO ABC", "TELLO-ÄBC", "TELLO-0123456789012345678901234567"} {
+		if err := validateSSID(ssid); err == nil {
+			t.Errorf("Expected '%s' to be rejected", ssid)
+		}
+	}
+}
+
+func TestValidateSSIDPassword(t *testing.T) {
+	if err := validateSSIDPassword("correct horse!"); err != nil {
+		t.Errorf("Expected valid password, got %v", err)
+	}
+	for _, pass := range []string{"short", "tab\tinside", string(make([]byte, 64))} {
+		if err := validateSSIDPassword(pass); err == nil {
+			t.Errorf("Expected '%q' to be rejected", pass)
+		}
+	}
+}
+
+func TestSetSSIDNotConnected(t *testing.T) {
+	var tello Tello
+	if err := tello.SetSSID("TELLO-TEST"); err != errNotConnected {
+		t.Errorf("Expected not connected error, got %v", err)
+	}
+}
+
+func TestValidateWifiRegion(t *testing.T) {
+	if err := validateWifiRegion(RegionUnitedKingdom); err != nil {
+		t.Errorf("Expected GB to be valid, got %v", err)
+	}
+	for _, region := range []WifiRegion{"", "us", "USA", "U1"} {
+		if err := validateWifiRegion(region); err == nil {
+			t.Errorf("Expected '%s' to be rejected", region)
+		}
+	}
+}